@@ -7,28 +7,82 @@
 // The cache is a generic type that can store any type of data. The cache is
 // implemented with an ordered map, which is a thread-safe map that remembers
 // the order of items. The cache is limited to the size specified when creating
-// a new cache object.
+// a new cache object, or to the size of items in bytes if the cache is created
+// by NewWithBytes.
 //
 // The cache provides the following methods:
 //   - Set: adds a new item to the cache. If the item already exists, the old
 //     item is replaced with the new one.
+//   - SetWithTTL: adds a new item to the cache which expires after the
+//     time-to-live.
 //   - Get: returns the item associated with the given key.
+//   - Peek: returns the item without changing the items order.
 //   - Del: deletes the item associated with the given key.
 //   - Len: returns the number of items in the cache.
+//   - Bytes: returns the size of items in the cache created by NewWithBytes.
+//   - Keys, Items: return keys and items from most recently used to least.
+//   - Sweep: removes expired items from the cache.
+//   - Stats, ResetStats: return and reset Get and Peek hits and misses.
+//   - StartJanitor: starts background goroutine which removes expired items.
+//   - Stop: stops the background goroutine.
 package cache
 
 import (
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/kirill-scherba/omap"
 )
 
 // Cache is a struct that contains an ordered map to store T objects. The
 // ordered map is implemented with omap, which is a thread-safe ordered map.
 // The size of the cache is limited to the value of the size field.
-type Cache[T any] struct {
+type Cache[T any] = KeyCache[string, T]
+
+// KeyCache is a cache with keys of type K. Cache is a KeyCache with string
+// keys.
+type KeyCache[K comparable, T any] struct {
+	// SlidingTTL enables sliding expiration: Get of an item with time-to-live
+	// resets its expiration time. Set it before using the cache.
+	SlidingTTL bool
+
+	// OnEvict is called with key and data of each item which Set removes
+	// because the cache size is exceeded. It is called after the item is
+	// removed and outside the cache lock, so it may use cache methods. Set it
+	// before using the cache.
+	OnEvict func(key K, data T)
+
+	// OnRemove is called with key and data of each item removed by Del, like
+	// OnEvict, including expired item which Del removes but does not return.
+	// Expired items removed by Get, Sweep or janitor are not reported. Set it
+	// before using the cache.
+	OnRemove func(key K, data T)
+
 	// Omap is an ordered map to store T objects.
-	m *omap.Omap[string, T]
+	m *omap.Omap[K, *entry[T]]
 	// size is the maximum number of elements in the cache.
 	size int
+	// norm is the key normalization function, nil if keys are not normalized.
+	norm func(K) K
+	// now returns current time, it is replaced by tests.
+	now func() time.Time
+	// ttl is the time-to-live of items added by Set, 0 if they never expire.
+	ttl time.Duration
+	// sizeOf returns size of item data in bytes, nil if the cache has no
+	// bytes budget.
+	sizeOf func(T) int64
+	// maxBytes is the maximum size of items in bytes.
+	maxBytes int64
+	// bytes is the size of items in bytes. It is changed under omap lock.
+	bytes atomic.Int64
+	// hits and misses are numbers of found and not found records by Get and
+	// Peek.
+	hits, misses atomic.Uint64
+	// stop stops the janitor goroutine, nil if janitor is not started.
+	stop chan struct{}
+	// mut protects stop.
+	mut sync.Mutex
 }
 
 // New creates new cache object.
@@ -41,18 +95,98 @@ type Cache[T any] struct {
 //   - c: the new cache object.
 //   - err: an error if the operation fails.
 func New[T any](size int) (c *Cache[T], err error) {
+	return NewWithKeyFunc[string, T](size, nil)
+}
+
+// NewWithTTL creates new cache object which items added by Set expire after
+// time-to-live ttl. Expired items are not returned by Get and are removed
+// lazily by Get, by Sweep or by janitor started with StartJanitor.
+//
+// Parameters:
+//   - size: the maximum number of elements in the cache. If size is 0,
+//     the cache has no limit.
+//   - ttl: the items time-to-live. If ttl is 0, the items never expire.
+//
+// Returns:
+//   - c: the new cache object.
+//   - err: an error if the operation fails.
+func NewWithTTL[T any](size int, ttl time.Duration) (c *Cache[T], err error) {
+	c, err = New[T](size)
+	if err != nil {
+		return
+	}
+	c.ttl = max(ttl, 0)
+	return
+}
+
+// NewWithBytes creates new cache object which size is limited by the size of
+// items in bytes. After each Set the least recently used items are evicted
+// until the size of items fits maxBytes, so an item larger than maxBytes is
+// evicted at once.
+//
+// Parameters:
+//   - maxBytes: the maximum size of items in bytes.
+//   - sizeOf: the function which returns size of item data in bytes.
+//
+// Returns:
+//   - c: the new cache object.
+//   - err: an error if the operation fails.
+func NewWithBytes[T any](maxBytes int64, sizeOf func(T) int64) (c *Cache[T],
+	err error) {
+
+	c, err = New[T](0)
+	if err != nil {
+		return
+	}
+	c.sizeOf, c.maxBytes = sizeOf, maxBytes
+	return
+}
+
+// NewWithKeyFunc creates new cache object with keys of type K which are
+// normalized by norm function before use.
+//
+// The cache stores normalized keys, so keys which have the same normalized
+// form are the same key. Use it when the logical key is a canonical form of
+// the provided key, for example struct keys with ignored fields.
+//
+// Parameters:
+//   - size: the maximum number of elements in the cache. If size is 0,
+//     the cache has no limit.
+//   - norm: the key normalization function. If norm is nil, keys are used
+//     as is.
+//
+// Returns:
+//   - c: the new cache object.
+//   - err: an error if the operation fails.
+func NewWithKeyFunc[K comparable, T any](size int, norm func(K) K) (
+	c *KeyCache[K, T], err error) {
+
 	// Create new omap object
-	m, err := omap.New[string, T]()
+	m, err := omap.New[K, *entry[T]]()
 	if err != nil {
 		return
 	}
 
 	// Create new Cache object
-	c = &Cache[T]{m, size}
+	c = &KeyCache[K, T]{m: m, size: size, norm: norm, now: time.Now}
 	return
 }
 
-// Add data to cache by key.
+// entry is a cache item which contains data and expiration time.
+type entry[T any] struct {
+	// data is the item data.
+	data T
+	// ttl is the item time-to-live, 0 if the item never expires.
+	ttl time.Duration
+	// size is the item data size in bytes, 0 if the cache has no bytes
+	// budget.
+	size int64
+	// expire is the item expiration time in unix nanoseconds.
+	expire atomic.Int64
+}
+
+// Add data to cache by key. The record expires after the cache time-to-live
+// if the cache was created by NewWithTTL.
 //
 // Parameters:
 //   - key: the key to add record to cache.
@@ -60,18 +194,81 @@ func New[T any](size int) (c *Cache[T], err error) {
 //
 // Returns:
 //   - err: an error if the operation fails.
-func (c *Cache[T]) Set(key string, data T) (err error) {
+func (c *KeyCache[K, T]) Set(key K, data T) (err error) {
+	return c.SetWithTTL(key, data, c.ttl)
+}
+
+// SetWithTTL adds data to cache by key with time-to-live. The record expires
+// after ttl, the expired record is not returned by Get and is removed from
+// cache by Get or Sweep. If SlidingTTL is set, Get resets the record
+// expiration time.
+//
+// Parameters:
+//   - key: the key to add record to cache.
+//   - data: the data to add to cache.
+//   - ttl: the record time-to-live. If ttl is 0, the record never expires.
+//
+// Returns:
+//   - err: an error if the operation fails.
+func (c *KeyCache[K, T]) SetWithTTL(key K, data T, ttl time.Duration) (
+	err error) {
+
+	key = c.key(key)
 
-	// Add new record to top of index list
-	err = c.m.SetFirst(key, data)
+	// Create cache entry
+	e := &entry[T]{data: data, ttl: max(ttl, 0)}
+	e.touch(c.now())
+	if c.sizeOf != nil {
+		e.size = c.sizeOf(data)
+	}
+
+	// Add new record to top of index list and remove last records if size is
+	// exceeded under one omap lock
+	evicted, err := c.set(key, e)
+
+	// Call eviction callback outside the lock
+	if c.OnEvict != nil {
+		for _, pair := range evicted {
+			c.OnEvict(pair.Key, pair.Value.data)
+		}
+	}
+
+	return
+}
+
+// set adds entry e to top of index list and removes last records while the
+// cache size or the size of items in bytes is exceeded. It returns removed
+// records.
+func (c *KeyCache[K, T]) set(key K, e *entry[T]) (
+	evicted []omap.Pair[K, *entry[T]], err error) {
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	// Replace size of existing entry
+	if old, ok := c.m.Get(key, true); ok {
+		c.bytes.Add(-old.size)
+	}
+	c.bytes.Add(e.size)
+
+	// Add entry and remove last records if cache size is exceeded
+	evicted, err = c.m.SetFirstLimit(key, e, c.size, true)
 	if err != nil {
 		return
 	}
+	for _, pair := range evicted {
+		c.bytes.Add(-pair.Value.size)
+	}
 
-	// Check cache size and remove last record if size is exceeded
-	if c.size > 0 && c.m.Len() > c.size {
-		// Remove last record from the cache
-		c.m.DelLast()
+	// Remove last records while the size of items is exceeded
+	for c.sizeOf != nil && c.bytes.Load() > c.maxBytes {
+		rec, old, ok := c.m.DelLast(true)
+		if !ok {
+			break
+		}
+		c.bytes.Add(-old.size)
+		evicted = append(evicted, omap.Pair[K, *entry[T]]{Key: rec.Key(),
+			Value: old})
 	}
 
 	return
@@ -85,14 +282,35 @@ func (c *Cache[T]) Set(key string, data T) (err error) {
 // Returns:
 //   - data: the data from cache if the operation is successful.
 //   - ok: true if the operation is successful.
-func (c *Cache[T]) Get(key string) (data T, ok bool) {
+func (c *KeyCache[K, T]) Get(key K) (data T, ok bool) {
+	key = c.key(key)
+	defer func() { c.stat(ok) }()
 
 	// Get players saves from cache
 	rec, ok := c.m.GetRecord(key)
 	if !ok {
 		return
 	}
-	data = rec.Data()
+	e := rec.Data()
+
+	// Remove expired record if it was not replaced
+	now := c.now()
+	if e.expired(now) {
+		c.m.Lock()
+		if r, found := c.m.GetRecord(key, true); found && r == rec {
+			c.m.Del(key, true)
+			c.bytes.Add(-e.size)
+		}
+		c.m.Unlock()
+		ok = false
+		return
+	}
+	data = e.data
+
+	// Reset expiration time in sliding mode
+	if c.SlidingTTL {
+		e.touch(now)
+	}
 
 	// Move players saves up in basic index lists
 	c.m.Idx.MoveUp(rec)
@@ -100,7 +318,30 @@ func (c *Cache[T]) Get(key string) (data T, ok bool) {
 	return
 }
 
-// Del removes record from cache by key.
+// Peek gets record from cache by key like Get, but does not move the record
+// up and does not reset its expiration time, so it can be used to inspect the
+// cache without changing eviction order. Expired record is not returned and is
+// not removed.
+//
+// Parameters:
+//   - key: the key to get record from cache.
+//
+// Returns:
+//   - data: the data from cache if the operation is successful.
+//   - ok: true if the operation is successful.
+func (c *KeyCache[K, T]) Peek(key K) (data T, ok bool) {
+	e, ok := c.m.Get(c.key(key))
+	if !ok || e.expired(c.now()) {
+		ok = false
+	} else {
+		data = e.data
+	}
+	c.stat(ok)
+	return
+}
+
+// Del removes record from cache by key. Expired record is removed too, but it
+// is not returned (ok is false). OnRemove is called for every removed record.
 //
 // Parameters:
 //   - key: the key to remove record from cache.
@@ -108,14 +349,183 @@ func (c *Cache[T]) Get(key string) (data T, ok bool) {
 // Returns:
 //   - data: the data from cache if the operation is successful.
 //   - ok: true if the operation is successful.
-func (c *Cache[T]) Del(key string) (data T, ok bool) {
-	return c.m.Del(key)
+func (c *KeyCache[K, T]) Del(key K) (data T, ok bool) {
+	key = c.key(key)
+	c.m.Lock()
+	e, ok := c.m.Del(key, true)
+	if ok {
+		c.bytes.Add(-e.size)
+	}
+	c.m.Unlock()
+	if !ok {
+		return
+	}
+
+	// Call remove callback
+	if c.OnRemove != nil {
+		c.OnRemove(key, e.data)
+	}
+
+	// Don't return expired record
+	if e.expired(c.now()) {
+		ok = false
+		return
+	}
+	data = e.data
+
+	return
+}
+
+// Sweep removes expired records from cache.
+//
+// Returns:
+//   - n: the number of removed records.
+func (c *KeyCache[K, T]) Sweep() (n int) {
+	now := c.now()
+	c.m.Reap(func(key K, e *entry[T]) bool {
+		return e.expired(now)
+	}, func(key K, e *entry[T]) {
+		c.bytes.Add(-e.size)
+		n++
+	})
+	return
+}
+
+// StartJanitor starts background goroutine which removes expired records from
+// cache with Sweep every interval. It restarts janitor if it is already
+// started. Use Stop to stop it.
+//
+// The janitor locks the cache ordered map only inside Sweep, so it does not
+// block Get and other methods between sweeps.
+//
+// Parameters:
+//   - interval: the interval between sweeps.
+func (c *KeyCache[K, T]) StartJanitor(interval time.Duration) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	c.stopJanitor()
+	stop := make(chan struct{})
+	c.stop = stop
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.Sweep()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops janitor started by StartJanitor. It does nothing if janitor is
+// not started.
+func (c *KeyCache[K, T]) Stop() {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	c.stopJanitor()
+}
+
+// stopJanitor stops janitor goroutine. Unsafe (does not lock).
+func (c *KeyCache[K, T]) stopJanitor() {
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
 }
 
 // Len returns the number of items in the cache.
 //
 // Returns:
 //   - len: the number of items in the cache.
-func (c *Cache[T]) Len() int {
+func (c *KeyCache[K, T]) Len() int {
 	return c.m.Len()
 }
+
+// Keys returns keys of not expired records in cache order, from most recently
+// used to least recently used. It does not change the records order.
+//
+// Returns:
+//   - keys: the keys of cache records.
+func (c *KeyCache[K, T]) Keys() (keys []K) {
+	for _, pair := range c.Items() {
+		keys = append(keys, pair.Key)
+	}
+	return
+}
+
+// Items returns key-value pairs of not expired records in cache order, from
+// most recently used to least recently used. It does not change the records
+// order.
+//
+// Returns:
+//   - items: the key-value pairs of cache records.
+func (c *KeyCache[K, T]) Items() (items []omap.Pair[K, T]) {
+	now := c.now()
+	c.m.ForEach(func(key K, e *entry[T]) {
+		if !e.expired(now) {
+			items = append(items, omap.Pair[K, T]{Key: key, Value: e.data})
+		}
+	})
+	return
+}
+
+// Stats returns numbers of records found (hits) and not found or expired
+// (misses) by Get and Peek since the cache was created or ResetStats was
+// called.
+//
+// Returns:
+//   - hits: the number of found records.
+//   - misses: the number of not found records.
+func (c *KeyCache[K, T]) Stats() (hits, misses uint64) {
+	return c.hits.Load(), c.misses.Load()
+}
+
+// ResetStats resets numbers of hits and misses returned by Stats.
+func (c *KeyCache[K, T]) ResetStats() {
+	c.hits.Store(0)
+	c.misses.Store(0)
+}
+
+// stat increments hits if ok is true and misses otherwise.
+func (c *KeyCache[K, T]) stat(ok bool) {
+	if ok {
+		c.hits.Add(1)
+		return
+	}
+	c.misses.Add(1)
+}
+
+// Bytes returns the size of items in the cache in bytes. It is 0 if the cache
+// is not created by NewWithBytes.
+//
+// Returns:
+//   - bytes: the size of items in the cache in bytes.
+func (c *KeyCache[K, T]) Bytes() int64 {
+	return c.bytes.Load()
+}
+
+// key returns normalized key.
+func (c *KeyCache[K, T]) key(key K) K {
+	if c.norm != nil {
+		return c.norm(key)
+	}
+	return key
+}
+
+// touch sets entry expiration time to now plus entry time-to-live.
+func (e *entry[T]) touch(now time.Time) {
+	if e.ttl > 0 {
+		e.expire.Store(now.Add(e.ttl).UnixNano())
+	}
+}
+
+// expired returns true if entry has time-to-live and is expired at now.
+func (e *entry[T]) expired(now time.Time) bool {
+	return e.ttl > 0 && now.UnixNano() >= e.expire.Load()
+}
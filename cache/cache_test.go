@@ -0,0 +1,320 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	t.Log("TestCache")
+
+	c, err := New[int](3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 5; i++ {
+		c.Set(fmt.Sprint(i), i)
+	}
+	if c.Len() != 3 {
+		t.Fatal("wrong cache length:", c.Len())
+	}
+	if _, ok := c.Get("2"); ok {
+		t.Fatal("evicted record found")
+	}
+	if data, ok := c.Get("5"); !ok || data != 5 {
+		t.Fatal("wrong record:", data, ok)
+	}
+}
+
+func TestCacheConcurrentSet(t *testing.T) {
+	t.Log("TestCacheConcurrentSet")
+
+	const size = 10
+	c, err := New[int](size)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Set records concurrently and check cache size
+	var wg sync.WaitGroup
+	for g := range 8 {
+		wg.Go(func() {
+			for i := range 1000 {
+				c.Set(fmt.Sprint(g, "-", i), i)
+				if l := c.Len(); l > size {
+					t.Errorf("cache size exceeded: %d", l)
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+
+	if c.Len() != size {
+		t.Fatal("wrong cache length:", c.Len())
+	}
+}
+
+func TestCacheKeyFunc(t *testing.T) {
+	t.Log("TestCacheKeyFunc")
+
+	type key struct {
+		ID      int
+		Session string // ignored
+	}
+	c, err := NewWithKeyFunc[key, string](10, func(k key) key {
+		return key{ID: k.ID}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Set(key{1, "a"}, "one")
+	if data, ok := c.Get(key{1, "b"}); !ok || data != "one" {
+		t.Fatal("wrong data by normalized key:", data, ok)
+	}
+	c.Set(key{1, "c"}, "uno")
+	if c.Len() != 1 {
+		t.Fatal("wrong cache length:", c.Len())
+	}
+	if data, ok := c.Del(key{ID: 1}); !ok || data != "uno" {
+		t.Fatal("wrong deleted data:", data, ok)
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	t.Log("TestCacheTTL")
+
+	for _, sliding := range []bool{false, true} {
+		c, err := New[int](10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.SlidingTTL = sliding
+
+		// Use fake clock
+		now := time.Now()
+		c.now = func() time.Time { return now }
+
+		c.SetWithTTL("ttl", 1, 100*time.Millisecond)
+		c.Set("forever", 2)
+
+		// Read record before it expires
+		now = now.Add(60 * time.Millisecond)
+		if _, ok := c.Get("ttl"); !ok {
+			t.Fatal("record expired too early, sliding:", sliding)
+		}
+
+		// Fixed record expires 100ms after Set, sliding record expires
+		// 100ms after Get
+		now = now.Add(60 * time.Millisecond)
+		if _, ok := c.Get("ttl"); ok != sliding {
+			t.Fatal("wrong expiration, sliding:", sliding)
+		}
+
+		// Sweep expired records
+		now = now.Add(120 * time.Millisecond)
+		c.Sweep()
+		if c.Len() != 1 {
+			t.Fatal("expired record was not swept, sliding:", sliding)
+		}
+		if _, ok := c.Get("forever"); !ok {
+			t.Fatal("record without ttl expired")
+		}
+	}
+}
+
+func TestCacheJanitor(t *testing.T) {
+	t.Log("TestCacheJanitor")
+
+	c, err := NewWithTTL[int](10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Use fake clock
+	var now atomic.Int64
+	now.Store(time.Now().UnixNano())
+	c.now = func() time.Time { return time.Unix(0, now.Load()) }
+
+	c.Set("one", 1)
+	c.SetWithTTL("forever", 2, 0)
+	if _, ok := c.Get("one"); !ok {
+		t.Fatal("record expired too early")
+	}
+
+	// Expire record and wait for janitor to remove it
+	now.Add(int64(2 * time.Minute))
+	c.StartJanitor(time.Millisecond)
+	defer c.Stop()
+	for deadline := time.Now().Add(5 * time.Second); c.Len() != 1; {
+		if time.Now().After(deadline) {
+			t.Fatal("expired record was not removed by janitor")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Stop is idempotent
+	c.Stop()
+	c.Stop()
+}
+
+func TestCacheOnEvict(t *testing.T) {
+	t.Log("TestCacheOnEvict")
+
+	c, err := New[int](2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var evicted, removed []string
+	c.OnEvict = func(key string, data int) {
+		evicted = append(evicted, fmt.Sprint(key, "=", data))
+
+		// Callback is called outside the lock
+		if _, ok := c.Get(key); ok {
+			t.Error("evicted record found")
+		}
+	}
+	c.OnRemove = func(key string, data int) {
+		removed = append(removed, fmt.Sprint(key, "=", data))
+	}
+
+	for i := 1; i <= 4; i++ {
+		c.Set(fmt.Sprint(i), i)
+	}
+	c.Del("4")
+
+	if fmt.Sprint(evicted) != "[1=1 2=2]" || fmt.Sprint(removed) != "[4=4]" {
+		t.Fatal("wrong callbacks:", evicted, removed)
+	}
+
+	// Expired record removed by Del is reported but not returned
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	c.SetWithTTL("5", 5, time.Second)
+	now = now.Add(2 * time.Second)
+	if _, ok := c.Del("5"); ok {
+		t.Fatal("expired record returned")
+	}
+	if fmt.Sprint(removed) != "[4=4 5=5]" || c.Len() != 1 {
+		t.Fatal("wrong expired record removal:", removed, c.Len())
+	}
+}
+
+func TestCachePeek(t *testing.T) {
+	t.Log("TestCachePeek")
+
+	c, err := New[int](2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Set("1", 1)
+	c.Set("2", 2)
+
+	// Peek does not move record up, so it is evicted first
+	if data, ok := c.Peek("1"); !ok || data != 1 {
+		t.Fatal("wrong peeked record:", data, ok)
+	}
+	c.Set("3", 3)
+	if _, ok := c.Peek("1"); ok {
+		t.Fatal("peeked record was not evicted")
+	}
+	if _, ok := c.Peek("2"); !ok {
+		t.Fatal("record evicted instead of peeked one")
+	}
+}
+
+func TestCacheKeys(t *testing.T) {
+	t.Log("TestCacheKeys")
+
+	c, err := New[int](10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Use fake clock
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	for i := 1; i <= 3; i++ {
+		c.Set(fmt.Sprint(i), i)
+	}
+	c.SetWithTTL("ttl", 4, time.Second)
+	now = now.Add(2 * time.Second)
+
+	if keys := fmt.Sprint(c.Keys()); keys != "[3 2 1]" {
+		t.Fatal("wrong keys:", keys)
+	}
+	if items := fmt.Sprint(c.Items()); items != "[{3 3} {2 2} {1 1}]" {
+		t.Fatal("wrong items:", items)
+	}
+	if c.Len() != 4 {
+		t.Fatal("keys changed cache:", c.Len())
+	}
+}
+
+func TestCacheBytes(t *testing.T) {
+	t.Log("TestCacheBytes")
+
+	c, err := NewWithBytes(10, func(data string) int64 {
+		return int64(len(data))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var evicted []string
+	c.OnEvict = func(key string, data string) {
+		evicted = append(evicted, key)
+	}
+
+	c.Set("a", "1234")
+	c.Set("b", "1234")
+	c.Set("a", "12") // replace size of existing record
+	if c.Bytes() != 6 || c.Len() != 2 {
+		t.Fatal("wrong size:", c.Bytes(), c.Len())
+	}
+
+	// Evict last records until size fits, updated record keeps its position
+	c.Set("c", "123456")
+	if c.Bytes() != 10 || fmt.Sprint(evicted) != "[a]" {
+		t.Fatal("wrong size after eviction:", c.Bytes(), evicted)
+	}
+
+	c.Del("b")
+	if c.Bytes() != 6 {
+		t.Fatal("wrong size after delete:", c.Bytes())
+	}
+
+	// Record larger than budget is evicted at once
+	c.Set("d", "12345678901")
+	if c.Bytes() != 0 || c.Len() != 0 {
+		t.Fatal("wrong size after large record:", c.Bytes(), c.Len())
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	t.Log("TestCacheStats")
+
+	c, err := New[int](10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Set("1", 1)
+
+	c.Get("1")
+	c.Get("2")
+	c.Peek("1")
+	c.Peek("3")
+	c.Get("4")
+	if hits, misses := c.Stats(); hits != 2 || misses != 3 {
+		t.Fatal("wrong stats:", hits, misses)
+	}
+
+	c.ResetStats()
+	if hits, misses := c.Stats(); hits != 0 || misses != 0 {
+		t.Fatal("stats not reset:", hits, misses)
+	}
+}
@@ -2,4 +2,7 @@ module github.com/kirill-scherba/omap
 
 go 1.25.5
 
-require golang.org/x/exp v0.0.0-20250210185358-939b2ce775ac
+require (
+	golang.org/x/exp v0.0.0-20250210185358-939b2ce775ac
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -10,10 +10,16 @@
 package omap
 
 import (
+	"cmp"
 	"container/list"
 	"errors"
+	"fmt"
 	"iter"
+	"math/rand/v2"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 
 	"golang.org/x/exp/constraints"
 )
@@ -24,11 +30,21 @@ var (
 	ErrKeyAllreadySet          = errors.New("key already exists")
 	ErrIncorrectIndexKey       = errors.New("incorrect index key name")
 	ErrIncorrectIndexDirection = errors.New("incorrect index direction")
+	ErrDefaultIndexSorted      = errors.New("default index is sorted by comparator")
+	ErrIndexSetNotFound        = errors.New("index set not found")
+	ErrNotJSONObject           = errors.New("json is not an object")
+	ErrForeignRecord           = errors.New("record does not belong to this map")
+	ErrMapFull                 = errors.New("map is full")
+	ErrUniqueConstraint        = errors.New("unique index constraint violated")
+	ErrTxnDone                 = errors.New("transaction is already committed or rolled back")
 )
 
 // Print mode is variable to enable print debug messages.
 var printMode = false
 
+// stringLimit is the maximum number of records printed by String.
+const stringLimit = 100
+
 // Omap is a concurrent safe multi index ordered map.
 type Omap[K comparable, D any] struct {
 
@@ -46,6 +62,49 @@ type Omap[K comparable, D any] struct {
 	// Indexes module
 	Idx *Indexes[K, D]
 
+	// Pool of records values, nil if records pool is disabled
+	pool *sync.Pool
+
+	// Key fold function, if set the map is keyed by folded keys while
+	// records keep original keys (see NewFold)
+	fold func(K) K
+
+	// Sort functions with context by index key (see Index.FuncCtx), they are
+	// bound to this map in sort functions map
+	ctxFuncs map[any]func(rec, next *Record[K, D], ctx *Omap[K, D]) int
+
+	// Sort functions of unique indexes by index key without tie-break
+	// function (see Index.Unique)
+	unique indexMap[K, D]
+
+	// Key transform function, if set keys are transformed before use and
+	// records keep transformed keys (see WithKeyTransform)
+	transform func(K) K
+
+	// Sort function which resolves ties of all index sort functions, nil if
+	// not set (see WithTieBreak)
+	tieBreak SortIndexFunc[K, D]
+
+	// Additional indexes are suspended (see SuspendIndexes)
+	suspended bool
+
+	// Number of moves of the last sort of each index by index key (see
+	// LastSortMoved)
+	moves map[any]int
+
+	// Number of index sorts which exceeded the moves limit (see
+	// SortLimitExceeded)
+	sortLimits atomic.Int64
+
+	// Journal of changes, nil if journal is not started
+	jrn *journal[K, D]
+
+	// Subscribers of Set events
+	subs []*subscriber[K, D]
+
+	// Changes buffered by transaction Commit, nil if changes are not buffered
+	pending *[]change[K, D]
+
 	// Mutex to protect ordered map operations
 	*sync.RWMutex
 }
@@ -57,9 +116,37 @@ type listMap map[any]*list.List
 type Index[K comparable, D any] struct {
 	Key  any
 	Func SortIndexFunc[K, D]
+
+	// Sort function which gets the ordered map as context, used instead of
+	// Func if set. It is called under ordered map Lock, so it may read the map
+	// only with unsafe methods, like ctx.Get(key, true), ctx.LenUnsafe() or
+	// ctx.RecordsUnsafe(). It is called for each compared pair, so computing
+	// aggregates of all records (like total) in it takes O(n) time for each
+	// comparison. New record is inserted at its position without sorting the
+	// index, so call Refresh after inserts which change the aggregates.
+	FuncCtx func(rec, next *Record[K, D], ctx *Omap[K, D]) int
+
+	// Unique index rejects records which are equal by its sort function to
+	// other record: Set and other methods which add or update records return
+	// ErrUniqueConstraint and keep the map unchanged. The tie-break function
+	// (see WithTieBreak) is not used by the check.
+	Unique bool
+
+	// Ordered map option, if set this Index is an option and not an index
+	// definition (see WithRecordPool)
+	option func(m *Omap[K, D])
 }
 type SortIndexFunc[K comparable, D any] func(rec, next *Record[K, D]) int
 
+// Direction is an iteration direction.
+type Direction int
+
+// Iteration directions
+const (
+	Forward  Direction = iota // From first to last record
+	Backward                  // From last to first record
+)
+
 // Pair represents a key-value pair in the ordered map.
 type Pair[K comparable, D any] struct {
 	Key   K
@@ -69,11 +156,42 @@ type Pair[K comparable, D any] struct {
 // New creates a new ordered map object with key of type T and data of type D.
 func New[K comparable, D any](sorts ...Index[K, D]) (m *Omap[K, D], err error) {
 
-	// Create new ordered map object and make maps
+	// Create new ordered map object
 	m = new(Omap[K, D])
+	m.init()
+
+	// Add sort indexes
+	for i := range sorts {
+		// Apply option
+		if sorts[i].option != nil {
+			sorts[i].option(m)
+			continue
+		}
+
+		// Add sort index
+		if err = m.addIndex(sorts[i]); err != nil {
+			return
+		}
+	}
+
+	// Add tie-break function to sort index functions
+	for k := range m.sm {
+		m.sm[k] = m.Idx.tieBroken(m.sm[k])
+	}
+
+	return
+}
+
+// init makes maps, default index and mutex of new ordered map.
+func (m *Omap[K, D]) init() {
+
+	// Make maps
 	m.m = make(dataMap[K, D])
 	m.lm = make(listMap)
 	m.sm = make(indexMap[K, D])
+	m.moves = make(map[any]int)
+	m.ctxFuncs = make(map[any]func(rec, next *Record[K, D], ctx *Omap[K, D]) int)
+	m.unique = make(indexMap[K, D])
 
 	m.Idx = (*Indexes[K, D])(m)
 
@@ -83,22 +201,136 @@ func New[K comparable, D any](sorts ...Index[K, D]) (m *Omap[K, D], err error) {
 	// Add default sort index
 	m.lm[0] = list.New()
 	m.sm[0] = nil
+}
 
-	// Add sort indexes
-	for i := range sorts {
-		// Skip default sort index TODO: return error
-		if sorts[i].Key == 0 {
-			err = ErrIncorrectIndexKey
-			return
-		}
-		// Add sort index function and create new list
-		m.sm[sorts[i].Key] = sorts[i].Func
-		m.lm[sorts[i].Key] = list.New()
+// addIndex adds sort index function and creates new empty index list. It
+// returns ErrIncorrectIndexKey if index key is not hashable, is the default
+// index key 0 or index already exists. Unsafe (does not lock).
+func (m *Omap[K, D]) addIndex(idx Index[K, D]) (err error) {
+
+	// Check index key can be used as map key
+	if !hashable(idx.Key) {
+		err = fmt.Errorf("%w: index key %v of type %T is not hashable",
+			ErrIncorrectIndexKey, idx.Key, idx.Key)
+		return
+	}
+
+	// Check default and existing index key
+	if _, ok := m.lm[idx.Key]; ok || idx.Key == 0 {
+		err = ErrIncorrectIndexKey
+		return
+	}
+
+	// Add sort index function and create new list
+	f := idx.Func
+	if fc := idx.FuncCtx; fc != nil {
+		m.ctxFuncs[idx.Key] = fc
+		f = m.bindCtx(fc)
+	}
+	m.sm[idx.Key] = f
+	m.lm[idx.Key] = list.New()
+	if idx.Unique && f != nil {
+		m.unique[idx.Key] = f
+	}
+
+	return
+}
+
+// bindCtx returns sort function which calls sort function with context fc
+// with this map as context.
+func (m *Omap[K, D]) bindCtx(fc func(rec, next *Record[K, D],
+	ctx *Omap[K, D]) int) SortIndexFunc[K, D] {
+
+	return func(rec, next *Record[K, D]) int { return fc(rec, next, m) }
+}
+
+// NewFold creates a new ordered map object with case-insensitive string keys
+// and data of type D.
+//
+// The Get, Set, Exists, Del and other methods find records by lower case key,
+// while records keep original key which is returned by iteration methods.
+// Keys which differ only in case are the same key: Set of such key updates
+// the record data and its original key (last write wins).
+func NewFold[D any](sorts ...Index[string, D]) (m *Omap[string, D], err error) {
+	m, err = New(sorts...)
+	if err != nil {
+		return
 	}
+	m.fold = strings.ToLower
 
 	return
 }
 
+// WithRecordPool returns option which enables pool of records values when
+// on is true. Pass it to New together with index definitions:
+//
+//	m, err := omap.New(omap.WithRecordPool[string, int](true))
+//
+// The pool reduces GC pressure in maps with high insert and delete churn
+// (like caches). The list elements are not pooled because container/list
+// does not allow to reuse them. Don't use records of removed keys when pool
+// is enabled, their values are reused by new records.
+func WithRecordPool[K comparable, D any](on bool) Index[K, D] {
+	return Index[K, D]{option: func(m *Omap[K, D]) {
+		if !on {
+			m.pool = nil
+			return
+		}
+		m.pool = &sync.Pool{New: func() any {
+			return &recordValue[K, D]{els: make(map[any]*list.Element)}
+		}}
+	}}
+}
+
+// WithKeyTransform returns option which sets key transform function f. Pass
+// it to New together with index definitions:
+//
+//	m, err := omap.New(omap.WithKeyTransform[string, int](func(key string) string {
+//		return strings.ToLower(strings.TrimSpace(key))
+//	}))
+//
+// Every method which gets key (Set, Get, Del and others) uses transformed key
+// and records keep transformed key, so keys which are transformed to the same
+// key are the same key. Unlike NewFold, original keys are not kept. Function f
+// must be idempotent: f(f(key)) == f(key).
+func WithKeyTransform[K comparable, D any](f func(K) K) Index[K, D] {
+	return Index[K, D]{option: func(m *Omap[K, D]) {
+		m.transform = f
+	}}
+}
+
+// WithTieBreak returns option which adds sort function f to all index sort
+// functions of the ordered map with Combine. Pass it to New together with
+// index definitions:
+//
+//	m, err := omap.New(
+//		omap.Index[string, *Person]{Key: "Age", Func: CompareByAge},
+//		omap.WithTieBreak(omap.CompareByKey[string, *Person]),
+//	)
+//
+// The function f is called only when index sort function reports records as
+// equal, so it overrides nothing but resolves ties. Use function which gives
+// total order (like CompareByKey) to make iteration order deterministic.
+func WithTieBreak[K comparable, D any](f SortIndexFunc[K, D]) Index[K, D] {
+	return Index[K, D]{option: func(m *Omap[K, D]) {
+		m.tieBreak = f
+	}}
+}
+
+// hashable reports whether key can be used as a map key. Keys of
+// not comparable types (slices, maps, functions or structs and arrays which
+// contain them) make map operations panic.
+func hashable(key any) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+
+	_ = map[any]struct{}{key: {}}
+	return true
+}
+
 // CompareByKey compares two records by their keys.
 //
 // This function returns a negative value if rec1 key is less than rec2 key,
@@ -120,25 +352,209 @@ func CompareByKey[K constraints.Ordered, D any](r1, r2 *Record[K, D]) int {
 	}
 }
 
-// Clear removes all records from ordered map.
+// CompareByKeyDesc compares two records by their keys in descending order. It
+// is the exact negation of CompareByKey.
+//
+// This function returns a negative value if rec1 key is greater than rec2 key,
+// zero if the keys are equal, and a positive value if rec1 key is less than
+// rec2 key.
+func CompareByKeyDesc[K constraints.Ordered, D any](r1, r2 *Record[K, D]) int {
+	return -CompareByKey(r1, r2)
+}
+
+// Combine returns sort function which compares two records with functions fs
+// in order and returns the first not zero result, or zero if all functions
+// report records as equal.
+func Combine[K comparable, D any](fs ...SortIndexFunc[K, D]) SortIndexFunc[K, D] {
+	return func(r1, r2 *Record[K, D]) int {
+		for _, f := range fs {
+			if c := f(r1, r2); c != 0 {
+				return c
+			}
+		}
+		return 0
+	}
+}
+
+// CompareByFunc returns sort function which compares two records by values
+// which function extract gets from their data, in ascending order.
+func CompareByFunc[K comparable, D any, V constraints.Ordered](extract func(D) V) SortIndexFunc[K, D] {
+	return func(r1, r2 *Record[K, D]) int {
+		return cmp.Compare(extract(r1.Data()), extract(r2.Data()))
+	}
+}
+
+// Reverse returns sort function which orders records in reverse order of
+// sort function f.
+func Reverse[K comparable, D any](f SortIndexFunc[K, D]) SortIndexFunc[K, D] {
+	return func(r1, r2 *Record[K, D]) int {
+		return f(r2, r1)
+	}
+}
+
+// CompareByKeyThen returns sort function which compares two records with
+// function f and, if f reports them equal, compares the records by their keys.
+//
+// Keys in ordered map are unique so the returned function never reports two
+// different records as equal, which gives the index a stable total order.
+func CompareByKeyThen[K constraints.Ordered, D any](f SortIndexFunc[K, D]) SortIndexFunc[K, D] {
+	return func(r1, r2 *Record[K, D]) int {
+		if c := f(r1, r2); c != 0 {
+			return c
+		}
+		return CompareByKey(r1, r2)
+	}
+}
+
+// CompareByValueThenKey compares two records by their values and, if the
+// values are equal, by their keys.
+func CompareByValueThenKey[K, D constraints.Ordered](r1, r2 *Record[K, D]) int {
+	data1 := r1.Data()
+	data2 := r2.Data()
+
+	switch {
+	case data1 > data2:
+		return 1
+
+	case data1 < data2:
+		return -1
+
+	default:
+		return CompareByKey(r1, r2)
+	}
+}
+
+// NilSafe returns sort function for maps with pointer data which orders
+// records with nil data last and compares records with not nil data with
+// function f. Two records with nil data are equal.
+//
+// Use it to protect sort functions which dereference data from panic on nil
+// data.
+func NilSafe[K comparable, D any](f SortIndexFunc[K, *D]) SortIndexFunc[K, *D] {
+	return nilSafe(f, 1)
+}
+
+// NilSafeFirst returns sort function like NilSafe which orders records with
+// nil data first.
+func NilSafeFirst[K comparable, D any](f SortIndexFunc[K, *D]) SortIndexFunc[K, *D] {
+	return nilSafe(f, -1)
+}
+
+// nilSafe returns sort function which compares records with nil data as
+// greater (nilOrder 1) or less (nilOrder -1) than records with not nil data.
+func nilSafe[K comparable, D any](f SortIndexFunc[K, *D], nilOrder int) SortIndexFunc[K, *D] {
+	return func(r1, r2 *Record[K, *D]) int {
+		data1, data2 := r1.Data(), r2.Data()
+		switch {
+		case data1 == nil && data2 == nil:
+			return 0
+		case data1 == nil:
+			return nilOrder
+		case data2 == nil:
+			return -nilOrder
+		default:
+			return f(r1, r2)
+		}
+	}
+}
+
+// Clear removes all records from ordered map. Index definitions are kept,
+// use ClearAll to remove additional indexes too.
 func (m *Omap[K, D]) Clear() {
 	m.Lock()
 	defer m.Unlock()
 
+	m.clear()
+}
+
+// clear removes all records from ordered map. Unsafe (does not lock).
+func (m *Omap[K, D]) clear() {
+
 	// Make data map and init index lists
 	m.m = make(dataMap[K, D])
 	for k := range m.lm {
 		m.lm[k].Init()
 	}
+
+	var key K
+	var data D
+	m.Idx.changed(JournalClear, key, data)
+}
+
+// ClearAll removes all records and all additional indexes from ordered map.
+// Only the default (insertion) index is kept, with its sort function if it
+// was set by SetDefaultComparator.
+//
+// Use Clear to remove records and keep index definitions.
+func (m *Omap[K, D]) ClearAll() {
+	m.Lock()
+	defer m.Unlock()
+
+	// Make data map and drop additional indexes
+	m.m = make(dataMap[K, D])
+	m.lm = listMap{0: m.lm[0].Init()}
+	m.sm = indexMap[K, D]{0: m.sm[0]}
+	m.moves = make(map[any]int)
+	clear(m.ctxFuncs)
+	clear(m.unique)
+
+	var key K
+	var data D
+	m.Idx.changed(JournalClear, key, data)
+}
+
+// IndexKeys returns keys of additional indexes of ordered map, the default
+// (insertion) index key 0 is not included. The order of keys is not
+// specified.
+func (m *Omap[K, D]) IndexKeys() (keys []any) {
+	m.RLock()
+	defer m.RUnlock()
+
+	for k := range m.lm {
+		if k != 0 {
+			keys = append(keys, k)
+		}
+	}
+
+	return
 }
 
 // Len returns the number of elements in the map.
 func (m *Omap[K, D]) Len() int {
 	m.RLock()
 	defer m.RUnlock()
+
+	return len(m.m)
+}
+
+// LenUnsafe returns the number of elements in the map like Len, but does not
+// lock ordered map. Use it in sort functions with context (see Index.FuncCtx)
+// or while the map is locked.
+func (m *Omap[K, D]) LenUnsafe() int {
 	return len(m.m)
 }
 
+// Count returns the number of records for which pred returns true. If pred is
+// nil, it returns the number of elements in the map like Len.
+//
+// The RLock is held during the walk, so function pred must not call omap
+// methods which use Lock avoid deadlocks.
+func (m *Omap[K, D]) Count(pred func(key K, data D) bool) (n int) {
+	m.RLock()
+	defer m.RUnlock()
+
+	if pred == nil {
+		return len(m.m)
+	}
+	for rec := m.Idx.first(); rec != nil; rec = m.Idx.next(rec) {
+		if pred(rec.Key(), rec.Data()) {
+			n++
+		}
+	}
+
+	return
+}
+
 // Set adds or updates record in ordered map by key. It adds new record to the
 // back of ordered map. If key already exists, its data will be updated.
 // Set unsafe to true to skip locking ordered map.
@@ -167,8 +583,16 @@ func (m *Omap[K, D]) SetFirst(key K, data D, unsafe ...bool) (err error) {
 	return m.set(key, data, front)
 }
 
-// Exists returns true if key exists in the map.
-func (m *Omap[K, D]) Exists(key K, unsafe ...bool) (exists bool) {
+// SetFirstLimit adds or updates record in ordered map by key like SetFirst and
+// then removes records from the back of default (insertion) index while the
+// map contains more than size records. It returns removed key-value pairs.
+// If size is 0 the map has no limit.
+//
+// Insertion and removal are executed under one Lock, so other goroutines
+// never see the map exceeding the size. Set unsafe to true to skip locking
+// ordered map.
+func (m *Omap[K, D]) SetFirstLimit(key K, data D, size int, unsafe ...bool) (
+	evicted []Pair[K, D], err error) {
 
 	// Lock ordered map if unsafe is not set or if first argument is false
 	if len(unsafe) == 0 || !unsafe[0] {
@@ -176,12 +600,30 @@ func (m *Omap[K, D]) Exists(key K, unsafe ...bool) (exists bool) {
 		defer m.Unlock()
 	}
 
-	_, exists = m.m[key]
+	// Add record to the front of ordered map
+	if err = m.set(key, data, front); err != nil {
+		return
+	}
+
+	// Remove records from the back of ordered map
+	for size > 0 && len(m.m) > size {
+		rec := m.Idx.elementToRecord(m.lm[0].Back())
+		evicted = append(evicted, Pair[K, D]{rec.Key(), rec.Data()})
+		m.Idx.removeRecord(rec)
+	}
+
 	return
 }
 
-// Get gets records data from ordered map by key. Returns ok true if found.
-func (m *Omap[K, D]) Get(key K, unsafe ...bool) (data D, ok bool) {
+// SetBounded adds or updates record in ordered map by key like Set, but
+// returns ErrMapFull and does not add new record when the map already contains
+// max records. Existing key is updated even if the map is full. If max is 0
+// the map has no limit.
+//
+// Use it for admission-controlled maps which reject new records rather than
+// evict old ones (see SetFirstLimit). Set unsafe to true to skip locking
+// ordered map.
+func (m *Omap[K, D]) SetBounded(key K, data D, max int, unsafe ...bool) error {
 
 	// Lock ordered map if unsafe is not set or if first argument is false
 	if len(unsafe) == 0 || !unsafe[0] {
@@ -189,64 +631,157 @@ func (m *Omap[K, D]) Get(key K, unsafe ...bool) (data D, ok bool) {
 		defer m.Unlock()
 	}
 
-	// Get list element
-	el, ok := m.m[key]
-	if !ok {
-		return
+	// Check map capacity for new key
+	if _, ok := m.m[m.Idx.mapKey(key)]; !ok && max > 0 && len(m.m) >= max {
+		return ErrMapFull
 	}
 
-	// Get records data
-	v, _ := el.Value.(*recordValue[K, D])
-	data = v.Data
+	return m.set(key, data, back)
+}
 
-	return
+// SetAll adds or updates records from key-value sequence seq in ordered map
+// like Set under one Lock and sorts indexes once after all records are set.
+// New records are added to the back of ordered map in order of sequence.
+//
+// Records which violate unique index (see Index.Unique) are skipped and the
+// other records are set. The returned error joins errors of skipped records,
+// it is nil if all records were set.
+//
+// The seq may be Records of other ordered map, maps.All of Go map or any
+// other sequence which does not call methods of this map which use mutex
+// avoid deadlocks.
+func (m *Omap[K, D]) SetAll(seq iter.Seq2[K, D]) error {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.putAll(seq)
 }
 
-// GetRecord gets record from ordered map by key. Returns ok true if found.
-func (m *Omap[K, D]) GetRecord(key K, unsafe ...bool) (rec *Record[K, D], ok bool) {
+// putAll adds or updates records from key-value sequence seq, skips records
+// which can't be set and sorts indexes once. It returns joined errors of
+// skipped records. Unsafe (does not lock).
+func (m *Omap[K, D]) putAll(seq iter.Seq2[K, D]) error {
+	var errs []error
+	for key, data := range seq {
+		if err := m.put(key, data, back); err != nil {
+			errs = append(errs, fmt.Errorf("key %v: %w", key, err))
+		}
+	}
+	m.Idx.sort()
 
-	// Lock ordered map if unsafe is not set or if first argument is false
-	if len(unsafe) == 0 || !unsafe[0] {
-		m.Lock()
-		defer m.Unlock()
+	return errors.Join(errs...)
+}
+
+// SetMany adds or updates records from pairs in ordered map like Set under one
+// Lock. New records are added to the back of ordered map in order of pairs.
+// New records are inserted into sorted index lists at their positions, and
+// indexes are sorted once after all pairs only if any existing record was
+// updated.
+//
+// It returns on the first error, records of pairs before it are set.
+func (m *Omap[K, D]) SetMany(pairs []Pair[K, D]) error {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.setMany(pairs, back)
+}
+
+// SetManyFirst adds or updates records from pairs in ordered map like SetMany,
+// but new records are added to the front of ordered map like SetFirst, so the
+// last new pair becomes the first record.
+func (m *Omap[K, D]) SetManyFirst(pairs []Pair[K, D]) error {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.setMany(pairs, front)
+}
+
+// setMany adds or updates records from pairs with direction and sorts indexes
+// once if any existing record was updated. Unsafe (does not lock).
+func (m *Omap[K, D]) setMany(pairs []Pair[K, D], direction int) (err error) {
+	var updated bool
+	for _, pair := range pairs {
+		_, exists := m.m[m.Idx.mapKey(pair.Key)]
+		if err = m.put(pair.Key, pair.Value, direction); err != nil {
+			break
+		}
+		updated = updated || exists
+	}
+	if updated {
+		m.Idx.sort()
 	}
 
-	// Get record
-	rec, ok = m.m[key]
 	return
 }
 
-// Del removes record from ordered map by key. Returns ok true and deleted data
-// if key exists, and record was successfully removed.
-func (m *Omap[K, D]) Del(key K, unsafe ...bool) (data D, ok bool) {
+// Merge adds records of other ordered map to this map in other default
+// (insertion) order. New records are added to the back of this map. If key
+// already exists, its data is updated when overwrite is true and kept
+// otherwise. Indexes are sorted once after all records if any existing record
+// was updated.
+//
+// Records which violate unique index (see Index.Unique) are skipped and the
+// other records are merged. The returned error joins errors of skipped
+// records, it is nil if all records were merged.
+//
+// Records of other map are copied under its RLock first and then set under
+// Lock of this map, so maps are never locked both at once: merging maps into
+// each other concurrently can't deadlock and merging map into itself is safe.
+func (m *Omap[K, D]) Merge(other *Omap[K, D], overwrite bool) error {
 
-	// Lock ordered map if unsafe is not set or if first argument is false
-	if len(unsafe) == 0 || !unsafe[0] {
-		m.Lock()
-		defer m.Unlock()
-	}
+	// Get records of other map
+	other.RLock()
+	pairs := other.appendPairs(nil)
+	other.RUnlock()
 
-	// Check if key exists and get data if exists
-	rec, ok := m.m[key]
-	if !ok {
-		return
-	}
-	data = rec.Data()
+	m.Lock()
+	defer m.Unlock()
 
-	// Remove element from lists
-	for k := range m.lm {
-		m.lm[k].Remove(rec.element())
+	// Skip existing keys if overwrite is not set
+	if !overwrite {
+		pairs = slices.DeleteFunc(pairs, func(pair Pair[K, D]) bool {
+			_, exists := m.m[m.Idx.mapKey(pair.Key)]
+			return exists
+		})
 	}
 
-	// Remove key from map
-	delete(m.m, key)
+	return m.putAll(func(yield func(K, D) bool) {
+		for _, pair := range pairs {
+			if !yield(pair.Key, pair.Value) {
+				return
+			}
+		}
+	})
+}
+
+// UpdateMany updates existing records and adds new records from updates map
+// under one Lock and sorts indexes once after all changes, so other goroutines
+// never see partial state. New records are added to the back of ordered map in
+// unspecified order (the order of Go map iteration). It returns number of
+// updated and added records.
+func (m *Omap[K, D]) UpdateMany(updates map[K]D) (applied int) {
+	m.Lock()
+	defer m.Unlock()
+
+	for key, data := range updates {
+		if m.put(key, data, back) == nil {
+			applied++
+		}
+	}
+	if applied > 0 {
+		m.Idx.sort()
+	}
 
 	return
 }
 
-// DelLast removes last record from ordered map by default index. Returns ok
-// true and deleted record if it was successfully removed.
-func (m *Omap[K, D]) DelLast(unsafe ...bool) (rec *Record[K, D], data D, ok bool) {
+// SetNoReindex adds or updates record in ordered map by key like Set, but when
+// key already exists it only updates its data and does not sort indexes.
+//
+// Use it for high-frequency updates of data fields which are not used by any
+// index sort function. If indexed fields were changed call Refresh to sort
+// indexes. Set unsafe to true to skip locking ordered map.
+func (m *Omap[K, D]) SetNoReindex(key K, data D, unsafe ...bool) error {
 
 	// Lock ordered map if unsafe is not set or if first argument is false
 	if len(unsafe) == 0 || !unsafe[0] {
@@ -254,35 +789,468 @@ func (m *Omap[K, D]) DelLast(unsafe ...bool) (rec *Record[K, D], data D, ok bool
 		defer m.Unlock()
 	}
 
-	// Get index list by key
-	list, ok := m.Idx.getList()
-	if !ok {
-		return
+	// Update data of existing record without sorting indexes
+	if rec, ok := m.m[m.Idx.mapKey(key)]; ok {
+		if err := m.Idx.checkUnique(rec.Key(), data, rec); err != nil {
+			return err
+		}
+		rec.Update(data)
+		m.Idx.changed(JournalSet, rec.Key(), data)
+		return nil
 	}
 
-	// Get last record
-	rec = m.Idx.elementToRecord(list.Back())
-	if rec == nil {
-		ok = false
-		return
-	}
+	return m.set(key, data, back)
+}
 
-	// Remove element from lists
-	for k := range m.lm {
-		m.lm[k].Remove(rec.element())
+// InsertMany adds new records from pairs to the back of ordered map under one
+// Lock. It returns ErrKeyAllreadySet on the first pair which key already
+// exists in the map (or repeats in pairs) and number of records inserted
+// before it. Set rollback to true to remove inserted records when error
+// occurs, so the map is left unchanged.
+func (m *Omap[K, D]) InsertMany(pairs []Pair[K, D], rollback ...bool) (
+	inserted int, err error) {
+
+	m.Lock()
+	defer m.Unlock()
+
+	for _, pair := range pairs {
+
+		// Check if key already exists
+		if _, ok := m.m[m.Idx.mapKey(pair.Key)]; ok {
+			err = ErrKeyAllreadySet
+			break
+		}
+
+		// Add new record to the back of ordered map
+		if err = m.set(pair.Key, pair.Value, back); err != nil {
+			break
+		}
+		inserted++
 	}
 
-	// Remove key from map
-	data = rec.Data()
-	delete(m.m, rec.Key())
+	// Remove inserted records on error if rollback is set
+	if err != nil && len(rollback) > 0 && rollback[0] {
+		for _, pair := range pairs[:inserted] {
+			m.Idx.removeRecord(m.m[m.Idx.mapKey(pair.Key)])
+		}
+		inserted = 0
+	}
 
 	return
 }
 
-// ForEach calls function f for each key present in the map.
+// UpdateFunc updates data of all records for which match returns true with
+// data returned by update and sorts indexes once after all updates. It returns
+// number of updated records. Records are processed in order of default
+// (insertion) index under one Lock. Records which new data violates unique
+// index (see Index.Unique) are not updated.
 //
-// By default, it iterates over default (insertion) index. Use idxKey to iterate
-// over other indexes.
+// Functions match and update must not call omap methods which use mutex avoid
+// deadlocks.
+func (m *Omap[K, D]) UpdateFunc(match func(key K, data D) bool,
+	update func(data D) D) (updated int) {
+
+	m.Lock()
+	defer m.Unlock()
+
+	for rec := m.Idx.first(); rec != nil; rec = m.Idx.next(rec) {
+		key, data := rec.Key(), rec.Data()
+		if !match(key, data) {
+			continue
+		}
+		data = update(data)
+		if m.Idx.checkUnique(key, data, rec) != nil {
+			continue
+		}
+		rec.Update(data)
+		m.Idx.changed(JournalSet, key, data)
+		updated++
+	}
+
+	// Sort indexes
+	if updated > 0 {
+		m.Idx.sort()
+	}
+
+	return
+}
+
+// Exists returns true if key exists in the map.
+func (m *Omap[K, D]) Exists(key K, unsafe ...bool) (exists bool) {
+
+	// Lock ordered map if unsafe is not set or if first argument is false
+	if len(unsafe) == 0 || !unsafe[0] {
+		m.Lock()
+		defer m.Unlock()
+	}
+
+	_, exists = m.m[m.Idx.mapKey(key)]
+	return
+}
+
+// Get gets records data from ordered map by key. Returns ok true if found.
+func (m *Omap[K, D]) Get(key K, unsafe ...bool) (data D, ok bool) {
+
+	// Lock ordered map if unsafe is not set or if first argument is false
+	if len(unsafe) == 0 || !unsafe[0] {
+		m.Lock()
+		defer m.Unlock()
+	}
+
+	// Get list element
+	el, ok := m.m[m.Idx.mapKey(key)]
+	if !ok {
+		return
+	}
+
+	// Get records data
+	v, _ := el.Value.(*recordValue[K, D])
+	data = v.Data
+
+	return
+}
+
+// GetRecord gets record from ordered map by key. Returns ok true if found.
+func (m *Omap[K, D]) GetRecord(key K, unsafe ...bool) (rec *Record[K, D], ok bool) {
+
+	// Lock ordered map if unsafe is not set or if first argument is false
+	if len(unsafe) == 0 || !unsafe[0] {
+		m.Lock()
+		defer m.Unlock()
+	}
+
+	// Get record
+	rec, ok = m.m[m.Idx.mapKey(key)]
+	return
+}
+
+// GetPairRecord gets key-value pair and record from ordered map by key.
+// Returns ok true if found. The record can be used to move or update the
+// record after it was read.
+func (m *Omap[K, D]) GetPairRecord(key K, unsafe ...bool) (pair Pair[K, D],
+	rec *Record[K, D], ok bool) {
+
+	// Lock ordered map if unsafe is not set or if first argument is false
+	if len(unsafe) == 0 || !unsafe[0] {
+		m.Lock()
+		defer m.Unlock()
+	}
+
+	// Get record and make pair
+	rec, ok = m.m[m.Idx.mapKey(key)]
+	if !ok {
+		return
+	}
+	pair = Pair[K, D]{Key: rec.Key(), Value: rec.Data()}
+
+	return
+}
+
+// LoadOrStoreRecord gets record from ordered map by key, or adds new record
+// with data to the back of ordered map if key does not exist. Returns the
+// record and loaded true if key already existed. The record can be used to
+// move it (for example to the front of ordered map) right after. Returns nil
+// record and loaded false if data violates unique index (see Index.Unique)
+// and the record was not added.
+func (m *Omap[K, D]) LoadOrStoreRecord(key K, data D, unsafe ...bool) (
+	rec *Record[K, D], loaded bool) {
+
+	// Lock ordered map if unsafe is not set or if first argument is false
+	if len(unsafe) == 0 || !unsafe[0] {
+		m.Lock()
+		defer m.Unlock()
+	}
+
+	// Get existing record
+	if rec, loaded = m.m[m.Idx.mapKey(key)]; loaded {
+		return
+	}
+
+	// Add new record
+	if m.set(key, data, back) != nil {
+		return
+	}
+	rec = m.m[m.Idx.mapKey(key)]
+
+	return
+}
+
+// GetOrSet gets records data from ordered map by key, or adds new record with
+// data to the back of ordered map if key does not exist. Returns actual data
+// of the record and loaded true if key already existed. The check and the
+// insert are executed under one Lock. Returns ErrUniqueConstraint if data
+// violates unique index (see Index.Unique), the record is not added then.
+func (m *Omap[K, D]) GetOrSet(key K, data D) (actual D, loaded bool, err error) {
+	return m.GetOrSetFunc(key, func() D { return data })
+}
+
+// GetOrSetFunc gets records data from ordered map by key like GetOrSet, or
+// adds new record with data returned by function f if key does not exist. The
+// function f is called only if key does not exist, under ordered map Lock, so
+// it must not call omap methods which use mutex avoid deadlocks.
+func (m *Omap[K, D]) GetOrSetFunc(key K, f func() D) (actual D, loaded bool,
+	err error) {
+
+	m.Lock()
+	defer m.Unlock()
+
+	// Get existing record
+	if rec, ok := m.m[m.Idx.mapKey(key)]; ok {
+		return rec.Data(), true, nil
+	}
+
+	// Add new record
+	data := f()
+	if err = m.set(key, data, back); err != nil {
+		return
+	}
+	actual = data
+
+	return
+}
+
+// SetIfAbsent adds new record with data to the back of ordered map if key
+// does not exist and returns true. It returns false and keeps existing data if
+// key already exists, or if data violates unique index (see Index.Unique). The
+// check and the insert are executed under one Lock.
+func (m *Omap[K, D]) SetIfAbsent(key K, data D) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	if _, ok := m.m[m.Idx.mapKey(key)]; ok {
+		return false
+	}
+
+	return m.set(key, data, back) == nil
+}
+
+// Update calls function f with data of record by key and exists true if key
+// exists, or with zero data and exists false otherwise, and stores data
+// returned by f if store is true: updates existing record and sorts indexes,
+// or adds new record to the back of ordered map. It returns the stored data
+// and true, or the old data and false if f did not store data. The call of f
+// and the store are executed under one Lock.
+//
+// Function f must not call omap methods which use mutex avoid deadlocks.
+func (m *Omap[K, D]) Update(key K, f func(old D, exists bool) (data D,
+	store bool)) (D, bool) {
+
+	m.Lock()
+	defer m.Unlock()
+
+	// Get existing data
+	var old D
+	rec, exists := m.m[m.Idx.mapKey(key)]
+	if exists {
+		old = rec.Data()
+	}
+
+	// Compute and store new data
+	data, store := f(old, exists)
+	if !store || m.set(key, data, back) != nil {
+		return old, false
+	}
+
+	return data, true
+}
+
+// Del removes record from ordered map by key. Returns ok true and deleted data
+// if key exists, and record was successfully removed.
+func (m *Omap[K, D]) Del(key K, unsafe ...bool) (data D, ok bool) {
+
+	// Lock ordered map if unsafe is not set or if first argument is false
+	if len(unsafe) == 0 || !unsafe[0] {
+		m.Lock()
+		defer m.Unlock()
+	}
+
+	// Check if key exists and get data if exists
+	rec, ok := m.m[m.Idx.mapKey(key)]
+	if !ok {
+		return
+	}
+	data = rec.Data()
+
+	// Remove record from index lists and map
+	m.Idx.removeRecord(rec)
+
+	return
+}
+
+// DelMany removes records with keys from ordered map under one Lock. Missing
+// keys are skipped. It returns number of removed records.
+func (m *Omap[K, D]) DelMany(keys []K) (deleted int) {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, key := range keys {
+		if rec, ok := m.m[m.Idx.mapKey(key)]; ok {
+			m.Idx.removeRecord(rec)
+			deleted++
+		}
+	}
+
+	return
+}
+
+// DelLast removes last record from ordered map by default index. Returns ok
+// true and deleted record if it was successfully removed. The returned record
+// is detached from the map when records pool is enabled (see WithRecordPool).
+func (m *Omap[K, D]) DelLast(unsafe ...bool) (rec *Record[K, D], data D, ok bool) {
+
+	// Lock ordered map if unsafe is not set or if first argument is false
+	if len(unsafe) == 0 || !unsafe[0] {
+		m.Lock()
+		defer m.Unlock()
+	}
+
+	return m.pop(m.Idx.last)
+}
+
+// PopFirst removes first record of index from ordered map under one Lock. By
+// default, it uses default (insertion) index. Use idxKey to use other indexes,
+// so map with sorted index can be used as priority queue. Returns ok true and
+// deleted record and data if it was successfully removed. The returned record
+// is detached from the map when records pool is enabled (see WithRecordPool).
+func (m *Omap[K, D]) PopFirst(idxKey ...any) (rec *Record[K, D], data D,
+	ok bool) {
+
+	m.Lock()
+	defer m.Unlock()
+
+	return m.pop(m.Idx.first, idxKey...)
+}
+
+// PopLast removes last record of index from ordered map like PopFirst.
+func (m *Omap[K, D]) PopLast(idxKey ...any) (rec *Record[K, D], data D,
+	ok bool) {
+
+	m.Lock()
+	defer m.Unlock()
+
+	return m.pop(m.Idx.last, idxKey...)
+}
+
+// pop removes record got by function get from index by index key. Unsafe
+// (does not lock).
+func (m *Omap[K, D]) pop(get func(...any) *Record[K, D], idxKey ...any) (
+	rec *Record[K, D], data D, ok bool) {
+
+	// Get record
+	rec = get(idxKey...)
+	if rec == nil {
+		return
+	}
+
+	// Remove record from index lists and map. Return detached copy of the
+	// record if records pool is enabled because removed record value is
+	// reused by the pool
+	key, data := rec.Key(), rec.Data()
+	m.Idx.removeRecord(rec)
+	if m.pool != nil {
+		rec = NewRecord(key, data)
+	}
+	ok = true
+
+	return
+}
+
+// Reap calls function f for each record for which pred returns true and then
+// removes this record from ordered map. Records are processed in order of
+// default (insertion) index under one Lock, so the inspection, the side
+// effects of f and the removal are atomic for other goroutines.
+//
+// Functions pred and f must not call omap methods which use mutex avoid
+// deadlocks.
+func (m *Omap[K, D]) Reap(pred func(key K, data D) bool, f func(key K, data D)) {
+	m.Lock()
+	defer m.Unlock()
+
+	var next *Record[K, D]
+	for rec := m.Idx.first(); rec != nil; rec = next {
+		next = m.Idx.next(rec)
+		key, data := rec.Key(), rec.Data()
+		if !pred(key, data) {
+			continue
+		}
+		f(key, data)
+		m.Idx.removeRecord(rec)
+	}
+}
+
+// DeleteFunc removes all records for which pred returns true from ordered map
+// and all index lists in one walk of default (insertion) index under one Lock.
+// It returns number of removed records.
+//
+// Function pred must not call omap methods which use mutex avoid deadlocks.
+func (m *Omap[K, D]) DeleteFunc(pred func(key K, data D) bool) (removed int) {
+	m.Lock()
+	defer m.Unlock()
+
+	var next *Record[K, D]
+	for rec := m.Idx.first(); rec != nil; rec = next {
+		next = m.Idx.next(rec)
+		if pred(rec.Key(), rec.Data()) {
+			m.Idx.removeRecord(rec)
+			removed++
+		}
+	}
+
+	return
+}
+
+// CompactFunc removes records which data is equal by function eq to data of
+// the previous kept record in default (insertion) index, like
+// slices.CompactFunc. It returns number of removed records.
+func (m *Omap[K, D]) CompactFunc(eq func(a, b D) bool) (removed int) {
+	m.Lock()
+	defer m.Unlock()
+
+	prev := m.Idx.first()
+	if prev == nil {
+		return
+	}
+
+	var next *Record[K, D]
+	for rec := m.Idx.next(prev); rec != nil; rec = next {
+		next = m.Idx.next(rec)
+		if !eq(prev.Data(), rec.Data()) {
+			prev = rec
+			continue
+		}
+		m.Idx.removeRecord(rec)
+		removed++
+	}
+
+	return
+}
+
+// TrimFront keeps only the first n records of the default (insertion) index
+// and removes the rest from the map and from all index lists.
+func (m *Omap[K, D]) TrimFront(n int) {
+	m.Lock()
+	defer m.Unlock()
+
+	for len(m.m) > max(n, 0) {
+		m.Idx.removeRecord(m.Idx.elementToRecord(m.lm[0].Back()))
+	}
+}
+
+// TrimBack keeps only the last n records of the default (insertion) index
+// and removes the rest from the map and from all index lists.
+func (m *Omap[K, D]) TrimBack(n int) {
+	m.Lock()
+	defer m.Unlock()
+
+	for len(m.m) > max(n, 0) {
+		m.Idx.removeRecord(m.Idx.elementToRecord(m.lm[0].Front()))
+	}
+}
+
+// ForEach calls function f for each key present in the map.
+//
+// By default, it iterates over default (insertion) index. Use idxKey to iterate
+// over other indexes.
 //
 // Function f is called for each key present in the map. The order of
 // iteration is determined by the index. If the index is not specified, the
@@ -290,63 +1258,681 @@ func (m *Omap[K, D]) DelLast(unsafe ...bool) (rec *Record[K, D], data D, ok bool
 // so the map cannot be modified during the iteration and any omap methods which
 // uses Lock cannot be used avoid deadlocks.
 func (m *Omap[K, D]) ForEach(f func(key K, data D), idxKey ...any) {
-	for key, data := range m.records(false, idxKey...) {
-		f(key, data)
+	m.Iterate(Forward, f, idxKey...)
+}
+
+// Iterate calls function f for each key present in the map in direction dir,
+// which is Forward or Backward.
+//
+// By default, it iterates over default (insertion) index. Use idxKey to iterate
+// over other indexes.
+//
+// The RLock is held during the iteration, so the map cannot be modified during
+// the iteration and any omap methods which uses Lock cannot be used avoid
+// deadlocks.
+func (m *Omap[K, D]) Iterate(dir Direction, f func(key K, data D),
+	idxKey ...any) {
+
+	m.RLock()
+	defer m.RUnlock()
+
+	// Select first record and next record function by direction
+	first, next := m.Idx.first, m.Idx.next
+	if dir == Backward {
+		first, next = m.Idx.last, m.Idx.prev
+	}
+
+	for rec := first(idxKey...); rec != nil; rec = next(rec) {
+		f(rec.Key(), rec.Data())
+	}
+}
+
+// ForEachShuffled calls function f for each key present in the map in
+// pseudo-random order determined by seed, so the same seed gives the same
+// order of the same map.
+//
+// The key-value pairs are copied under RLock and then shuffled and iterated
+// without lock, so function f may call any omap methods.
+func (m *Omap[K, D]) ForEachShuffled(seed int64, f func(key K, data D)) {
+	pairs := m.Pairs()
+
+	r := rand.New(rand.NewPCG(uint64(seed), 0))
+	r.Shuffle(len(pairs), func(i, j int) {
+		pairs[i], pairs[j] = pairs[j], pairs[i]
+	})
+
+	for _, pair := range pairs {
+		f(pair.Key, pair.Value)
+	}
+}
+
+// ForEachRecord calls function f for each record present in the map.
+//
+// By default, it iterates over default (insertion) index. Use idxKey to iterate
+// over other indexes.
+//
+// It allows to handle records directly, which could be useful for example to
+// call methods on the record, or to get the index of the record in the list.
+//
+// Function f is called for each key present in the map. The RLock is held
+// during the iteration, so the map cannot be modified during the iteration and
+// any omap methods which uses Lock cannot be used avoid deadlocks.
+func (m *Omap[K, D]) ForEachRecord(f func(rec *Record[K, D]), idxKey ...any) {
+	m.RLock()
+	defer m.RUnlock()
+
+	for rec := m.Idx.first(idxKey...); rec != nil; rec = m.Idx.next(rec) {
+		f(rec)
+	}
+}
+
+// ForEachRecordIndexed calls function f for each record present in the map
+// and passes zero-based position of the record in the index list.
+//
+// By default, it iterates over default (insertion) index. Use idxKey to iterate
+// over other indexes.
+//
+// The RLock is held during the iteration, so the map cannot be modified during
+// the iteration and any omap methods which uses Lock cannot be used avoid
+// deadlocks.
+func (m *Omap[K, D]) ForEachRecordIndexed(f func(i int, rec *Record[K, D]),
+	idxKey ...any) {
+
+	m.RLock()
+	defer m.RUnlock()
+
+	i := 0
+	for rec := m.Idx.first(idxKey...); rec != nil; rec = m.Idx.next(rec) {
+		f(i, rec)
+		i++
+	}
+}
+
+// ForEachPair calls function f for each key-value pair present in the map.
+//
+// By default, it iterates over default (insertion) index. Use idxKey to iterate
+// over other indexes.
+//
+// It allows to handle key-value pairs directly, which could be useful for
+// example to call methods on the pair, or to get the index of the pair in the
+// list.
+//
+// Function f is called for each key present in the map. The RLock is held
+// during the iteration, so the map cannot be modified during the iteration and
+// any omap methods which uses Lock cannot be used avoid deadlocks.
+func (m *Omap[K, D]) ForEachPair(f func(pair Pair[K, D]), idxKey ...any) {
+	m.RLock()
+	defer m.RUnlock()
+
+	for rec := m.Idx.first(idxKey...); rec != nil; rec = m.Idx.next(rec) {
+		f(Pair[K, D]{Key: rec.Key(), Value: rec.Data()})
+	}
+}
+
+// ForEachOf calls function f for each key from keys slice in order of the
+// slice. Function f gets the key, its data and found flag which is false if
+// the key does not exist in the map.
+//
+// The RLock is held during the iteration, so any omap methods which uses Lock
+// cannot be used inside function f avoid deadlocks.
+func (m *Omap[K, D]) ForEachOf(keys []K, f func(key K, data D, ok bool)) {
+	m.RLock()
+	defer m.RUnlock()
+
+	for _, key := range keys {
+		var data D
+		rec, ok := m.m[m.Idx.mapKey(key)]
+		if ok {
+			data = rec.Data()
+		}
+		f(key, data, ok)
+	}
+}
+
+// GroupByValue returns groups of keys which values are equal by function eq.
+// Groups are ordered by the first key of the group and keys inside groups are
+// ordered by default (insertion) index.
+func (m *Omap[K, D]) GroupByValue(eq func(a, b D) bool) (groups [][]K) {
+	m.RLock()
+	defer m.RUnlock()
+
+	var values []D
+	for rec := m.Idx.first(); rec != nil; rec = m.Idx.next(rec) {
+		data := rec.Data()
+
+		// Add key to existing group
+		i := slices.IndexFunc(values, func(v D) bool { return eq(v, data) })
+		if i >= 0 {
+			groups[i] = append(groups[i], rec.Key())
+			continue
+		}
+
+		// Create new group
+		values = append(values, data)
+		groups = append(groups, []K{rec.Key()})
+	}
+
+	return
+}
+
+// Find returns the first record for which pred returns true and ok true, or
+// nil and ok false if there is no such record. By default, it uses default
+// (insertion) index. Use idxKey to use other indexes.
+//
+// Records are checked from the front of the index in index order and the
+// walk stops at the first match, so sorted index lets pred find the first
+// record which exceeds a bound.
+//
+// The RLock is held during the walk, so function pred must not call omap
+// methods which use Lock avoid deadlocks.
+func (m *Omap[K, D]) Find(pred func(rec *Record[K, D]) bool, idxKey ...any) (
+	rec *Record[K, D], ok bool) {
+
+	m.RLock()
+	defer m.RUnlock()
+
+	for rec = m.Idx.first(idxKey...); rec != nil; rec = m.Idx.next(rec) {
+		if pred(rec) {
+			return rec, true
+		}
+	}
+
+	return
+}
+
+// SeekByIndex finds record in sorted index by probe record using the index
+// sort function. It returns the record equal to probe and exact true if such
+// record exists, otherwise it returns the first record ordered after probe
+// and exact false. It returns nil if probe is ordered after all records or if
+// index does not exist or has no sort function.
+//
+// The index list is scanned forward from the front, so it takes O(n) time.
+func (m *Omap[K, D]) SeekByIndex(idxKey any, probe *Record[K, D]) (
+	rec *Record[K, D], exact bool) {
+
+	m.RLock()
+	defer m.RUnlock()
+
+	// Get index sort function
+	f := m.sm[idxKey]
+	if f == nil || probe == nil {
+		return
+	}
+
+	// Find first record which is not less than probe
+	for rec = m.Idx.first(idxKey); rec != nil; rec = m.Idx.next(rec) {
+		if c := f(rec, probe); c >= 0 {
+			exact = c == 0
+			return
+		}
+	}
+
+	return
+}
+
+// Pairs returns a slice of key-value pairs in the omap. By default, it iterates
+// over default (insertion) index. Use idxKey to iterate over other indexes.
+func (m *Omap[K, D]) Pairs(idxKey ...any) (pairs []Pair[K, D]) {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.appendPairs(make([]Pair[K, D], 0, len(m.m)), idxKey...)
+}
+
+// Keys returns a slice of keys in the omap. By default, it iterates over
+// default (insertion) index. Use idxKey to iterate over other indexes. It
+// returns empty not nil slice if the map is empty.
+func (m *Omap[K, D]) Keys(idxKey ...any) []K {
+	return Project(m, func(key K, data D) K { return key }, idxKey...)
+}
+
+// Values returns a slice of values in the omap. By default, it iterates over
+// default (insertion) index. Use idxKey to iterate over other indexes. It
+// returns empty not nil slice if the map is empty.
+func (m *Omap[K, D]) Values(idxKey ...any) []D {
+	return Project(m, func(key K, data D) D { return data }, idxKey...)
+}
+
+// ToMap returns a Go map with keys and data of all records of the omap. The
+// returned map is detached from the omap, but data is copied by assignment,
+// so the map shares data with the omap if D is a pointer or other reference
+// type.
+func (m *Omap[K, D]) ToMap() map[K]D {
+	m.RLock()
+	defer m.RUnlock()
+
+	res := make(map[K]D, len(m.m))
+	for rec := m.Idx.first(); rec != nil; rec = m.Idx.next(rec) {
+		res[rec.Key()] = rec.Data()
+	}
+
+	return res
+}
+
+// AppendPairs appends key-value pairs of the omap to dst and returns the
+// extended slice. By default, it iterates over default (insertion) index. Use
+// idxKey to iterate over other indexes.
+//
+// Reuse the returned slice as dst (with dst[:0]) in repeated calls to avoid
+// allocations.
+func (m *Omap[K, D]) AppendPairs(dst []Pair[K, D], idxKey ...any) []Pair[K, D] {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.appendPairs(dst, idxKey...)
+}
+
+// appendPairs appends key-value pairs of the omap to dst. Unsafe (does not
+// lock).
+func (m *Omap[K, D]) appendPairs(dst []Pair[K, D], idxKey ...any) []Pair[K, D] {
+	dst = slices.Grow(dst, len(m.m))
+	for rec := m.Idx.first(idxKey...); rec != nil; rec = m.Idx.next(rec) {
+		dst = append(dst, Pair[K, D]{Key: rec.Key(), Value: rec.Data()})
+	}
+	return dst
+}
+
+// Project returns slice of values made by function f from each record of the
+// omap in index order. By default, it iterates over default (insertion)
+// index. Use idxKey to iterate over other indexes.
+//
+// The RLock is held during the iteration, so function f must not call omap
+// methods which use Lock avoid deadlocks.
+func Project[K comparable, D, R any](m *Omap[K, D], f func(key K, data D) R,
+	idxKey ...any) []R {
+
+	m.RLock()
+	defer m.RUnlock()
+
+	values := make([]R, 0, len(m.m))
+	for rec := m.Idx.first(idxKey...); rec != nil; rec = m.Idx.next(rec) {
+		values = append(values, f(rec.Key(), rec.Data()))
+	}
+
+	return values
+}
+
+// Prefix returns key-value pairs of records of map m which keys begin with
+// prefix in index order. By default, it uses default (insertion) index. Use
+// idxKey to use other indexes. The index must be sorted by keys in ascending
+// order (for example by CompareByKey), otherwise records may be missed. It
+// returns nil if index does not exist or has no sort function, like the not
+// sorted default (insertion) index.
+//
+// The index list is scanned forward from the front to the first record after
+// the keys with prefix, so it takes O(n) time in the worst case.
+func Prefix[K ~string, D any](m *Omap[K, D], prefix K, idxKey ...any) []Pair[K, D] {
+	m.RLock()
+	defer m.RUnlock()
+
+	// Check index has sort function
+	var k any = 0
+	if len(idxKey) > 0 {
+		k = idxKey[0]
+	}
+	if m.sm[k] == nil {
+		return nil
+	}
+
+	pairs := []Pair[K, D]{}
+	for rec := m.Idx.first(k); rec != nil; rec = m.Idx.next(rec) {
+		key := rec.Key()
+		if key < prefix {
+			continue
+		}
+		if !strings.HasPrefix(string(key), string(prefix)) {
+			break
+		}
+		pairs = append(pairs, Pair[K, D]{Key: key, Value: rec.Data()})
+	}
+
+	return pairs
+}
+
+// TopK returns up to k first key-value pairs of index by index key, which are
+// k smallest records of the index by its sort function. It returns nil if
+// index does not exist.
+//
+// The index is kept sorted, so it takes O(k) time.
+func (m *Omap[K, D]) TopK(idxKey any, k int) []Pair[K, D] {
+	return m.walkK(m.Idx.first, m.Idx.next, k, idxKey)
+}
+
+// BottomK returns up to k last key-value pairs of index by index key in
+// backward order, which are k largest records of the index by its sort
+// function, the largest first. It returns nil if index does not exist.
+//
+// The index is kept sorted, so it takes O(k) time.
+func (m *Omap[K, D]) BottomK(idxKey any, k int) []Pair[K, D] {
+	return m.walkK(m.Idx.last, m.Idx.prev, k, idxKey)
+}
+
+// FirstN returns up to n first key-value pairs of the omap. By default, it
+// uses default (insertion) index. Use idxKey to use other indexes. It returns
+// all records if n is greater than the map length, and empty not nil slice if
+// n <= 0.
+//
+// Only n records are walked, so use it instead of Pairs to get the first page
+// of a large map.
+func (m *Omap[K, D]) FirstN(n int, idxKey ...any) []Pair[K, D] {
+	pairs := m.walkK(m.Idx.first, m.Idx.next, n, idxKey...)
+	if pairs == nil {
+		pairs = []Pair[K, D]{}
+	}
+	return pairs
+}
+
+// LastN returns up to n last key-value pairs of the omap in index order. By
+// default, it uses default (insertion) index. Use idxKey to use other indexes.
+// It returns all records if n is greater than the map length, and empty not
+// nil slice if n <= 0.
+//
+// Only n records are walked from the back of the index.
+func (m *Omap[K, D]) LastN(n int, idxKey ...any) []Pair[K, D] {
+	pairs := m.walkK(m.Idx.last, m.Idx.prev, n, idxKey...)
+	if pairs == nil {
+		pairs = []Pair[K, D]{}
+	}
+	slices.Reverse(pairs)
+	return pairs
+}
+
+// Slice returns up to limit key-value pairs of the omap which follow the
+// first offset records. By default, it uses default (insertion) index. Use
+// idxKey to use other indexes. Negative limit means all records after offset.
+// It returns empty not nil slice if offset is out of range.
+//
+// Index lists are linked lists, so it takes O(offset+limit) time.
+func (m *Omap[K, D]) Slice(offset, limit int, idxKey ...any) []Pair[K, D] {
+	m.RLock()
+	defer m.RUnlock()
+
+	pairs := []Pair[K, D]{}
+	if offset < 0 || limit == 0 {
+		return pairs
+	}
+
+	rec := m.Idx.first(idxKey...)
+	for ; rec != nil && offset > 0; rec = m.Idx.next(rec) {
+		offset--
+	}
+	for ; rec != nil && (limit < 0 || len(pairs) < limit); rec = m.Idx.next(rec) {
+		pairs = append(pairs, Pair[K, D]{Key: rec.Key(), Value: rec.Data()})
+	}
+
+	return pairs
+}
+
+// walkK returns up to k key-value pairs of index by index key walking from
+// first record with next function.
+func (m *Omap[K, D]) walkK(first func(...any) *Record[K, D],
+	next func(*Record[K, D]) *Record[K, D], k int, idxKey ...any) (
+	pairs []Pair[K, D]) {
+
+	m.RLock()
+	defer m.RUnlock()
+
+	for rec := first(idxKey...); rec != nil && len(pairs) < k; rec = next(rec) {
+		pairs = append(pairs, Pair[K, D]{Key: rec.Key(), Value: rec.Data()})
+	}
+
+	return
+}
+
+// MapAll returns new ordered map with the same keys in the same default
+// (insertion) order as map m and data made by function f from each record.
+//
+// Index definitions and default comparator are typed on data type of map m,
+// so they can't be carried over and the new map has only the default index
+// without sort function. Key fold and key transform functions are kept.
+//
+// The RLock of map m is held during the mapping, so function f must not call
+// methods of map m which use Lock avoid deadlocks.
+func MapAll[K comparable, D, R any](m *Omap[K, D], f func(key K, data D) R) *Omap[K, R] {
+	r, _ := New[K, R]()
+
+	m.RLock()
+	defer m.RUnlock()
+
+	r.fold, r.transform = m.fold, m.transform
+	for rec := m.Idx.first(); rec != nil; rec = m.Idx.next(rec) {
+		key := rec.Key()
+		r.m[r.Idx.mapKey(key)] = r.Idx.insert(key, f(key, rec.Data()), back, nil)
+	}
+
+	return r
+}
+
+// Clone returns a copy of ordered map with the same index definitions,
+// options and records in the same order. All index lists of the copy are
+// rebuilt. Journal and subscribers are not copied.
+//
+// It is a shallow copy: the map and index lists are new, but data is copied
+// by assignment, so pointer data is shared between maps. Use CloneFunc to
+// copy data deeply.
+func (m *Omap[K, D]) Clone() *Omap[K, D] {
+	return m.CloneFunc(nil)
+}
+
+// CloneFunc returns a copy of ordered map like Clone with data of each record
+// copied by function f. If f is nil, data is copied by assignment.
+//
+// The RLock is held during the copy, so function f must not call omap methods
+// which use Lock avoid deadlocks.
+func (m *Omap[K, D]) CloneFunc(f func(data D) D) *Omap[K, D] {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.clone(nil, f)
+}
+
+// Filter returns new ordered map with the same index definitions and options
+// which contains only records for which pred returns true, in the same
+// default (insertion) order. The new map is independent of map m, but data is
+// copied by assignment like in Clone.
+//
+// The RLock is held during the filtering, so function pred must not call omap
+// methods which use Lock avoid deadlocks.
+func (m *Omap[K, D]) Filter(pred func(key K, data D) bool) *Omap[K, D] {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.clone(pred, nil)
+}
+
+// clone returns a copy of ordered map with records for which keep returns
+// true, or with all records if keep is nil, and data copied by function f,
+// or by assignment if f is nil. Unsafe (does not lock).
+func (m *Omap[K, D]) clone(keep func(key K, data D) bool,
+	f func(data D) D) *Omap[K, D] {
+
+	// Create new ordered map with the same options and index definitions
+	c := new(Omap[K, D])
+	c.init()
+	c.fold, c.transform, c.tieBreak = m.fold, m.transform, m.tieBreak
+	if m.pool != nil {
+		WithRecordPool[K, D](true).option(c)
+	}
+	for k, sf := range m.sm {
+		c.sm[k] = sf
+		if k != 0 {
+			c.lm[k] = list.New()
+		}
+	}
+	for k, fc := range m.ctxFuncs {
+		c.ctxFuncs[k] = fc
+		c.sm[k] = c.Idx.tieBroken(c.bindCtx(fc))
+	}
+	for k, uf := range m.unique {
+		if fc, ok := m.ctxFuncs[k]; ok {
+			uf = c.bindCtx(fc)
+		}
+		c.unique[k] = uf
+	}
+
+	// Copy records to default index and rebuild additional indexes
+	c.suspended = true
+	for rec := m.Idx.first(); rec != nil; rec = m.Idx.next(rec) {
+		key, data := rec.Key(), rec.Data()
+		if keep != nil && !keep(key, data) {
+			continue
+		}
+		if f != nil {
+			data = f(data)
+		}
+		c.m[c.Idx.mapKey(key)] = c.Idx.insert(key, data, back, nil)
+	}
+	c.suspended = false
+	for k := range c.lm {
+		if k != 0 {
+			c.Idx.rebuild(k)
+		}
 	}
+
+	return c
 }
 
-// ForEachRecord calls function f for each record present in the map.
-//
-// By default, it iterates over default (insertion) index. Use idxKey to iterate
-// over other indexes.
-//
-// It allows to handle records directly, which could be useful for example to
-// call methods on the record, or to get the index of the record in the list.
+// RebuildIndex discards index list by index key, fills it with all records
+// of the map and sorts it. It returns ErrIncorrectIndexKey if index does not
+// exist or if idxKey is the default (insertion) index key 0.
 //
-// Function f is called for each key present in the map. The RLock is held
-// during the iteration, so the map cannot be modified during the iteration and
-// any omap methods which uses Lock cannot be used avoid deadlocks.
-func (m *Omap[K, D]) ForEachRecord(f func(rec *Record[K, D]), idxKey ...any) {
-	m.RLock()
-	defer m.RUnlock()
+// Use it to repair index which membership diverged from the map data. To sort
+// index list after the map data was changed directly use Refresh.
+func (m *Omap[K, D]) RebuildIndex(idxKey any) (err error) {
+	m.Lock()
+	defer m.Unlock()
 
-	for rec := m.Idx.first(idxKey...); rec != nil; rec = m.Idx.next(rec) {
-		f(rec)
+	// Check index key
+	if _, ok := m.lm[idxKey]; !ok || idxKey == 0 {
+		err = ErrIncorrectIndexKey
+		return
 	}
+
+	m.Idx.rebuild(idxKey)
+
+	return
 }
 
-// ForEachPair calls function f for each key-value pair present in the map.
-//
-// By default, it iterates over default (insertion) index. Use idxKey to iterate
-// over other indexes.
-//
-// It allows to handle key-value pairs directly, which could be useful for
-// example to call methods on the pair, or to get the index of the pair in the
-// list.
+// Validate checks ordered map consistency and returns error describing the
+// first found violation or nil if the map is consistent.
 //
-// Function f is called for each key present in the map. The RLock is held
-// during the iteration, so the map cannot be modified during the iteration and
-// any omap methods which uses Lock cannot be used avoid deadlocks.
-func (m *Omap[K, D]) ForEachPair(f func(pair Pair[K, D]), idxKey ...any) {
-	for key, data := range m.records(false, idxKey...) {
-		f(Pair[K, D]{Key: key, Value: data})
+// It checks that every index list has the same number of elements as the map,
+// that every key of the map appears exactly once in every index list and that
+// every index list is sorted by its sort function. It is useful in tests and
+// for debugging.
+func (m *Omap[K, D]) Validate() error {
+	m.RLock()
+	defer m.RUnlock()
+
+	for idxKey, l := range m.lm {
+
+		// Check index list length
+		if l.Len() != len(m.m) {
+			return fmt.Errorf("index %v: list has %d elements, map has %d keys",
+				idxKey, l.Len(), len(m.m))
+		}
+
+		// Check that every key of the map appears once in the list
+		seen := make(map[K]struct{}, l.Len())
+		for el := l.Front(); el != nil; el = el.Next() {
+			rec := m.Idx.elementToRecord(el)
+			key := rec.Key()
+			if _, ok := seen[key]; ok {
+				return fmt.Errorf("index %v: key %v appears more than once",
+					idxKey, key)
+			}
+			seen[key] = struct{}{}
+
+			mrec, ok := m.m[m.Idx.mapKey(key)]
+			if !ok || mrec.value() != rec.value() {
+				return fmt.Errorf("index %v: key %v not found in map",
+					idxKey, key)
+			}
+			if rec.value().els[idxKey] != el {
+				return fmt.Errorf("index %v: key %v has wrong list element",
+					idxKey, key)
+			}
+		}
+
+		// Check that list is sorted
+		f := m.sm[idxKey]
+		if f == nil {
+			continue
+		}
+		for el := l.Front(); el != nil && el.Next() != nil; el = el.Next() {
+			rec, next := m.Idx.elementToRecord(el), m.Idx.elementToRecord(el.Next())
+			if f(rec, next) > 0 {
+				return fmt.Errorf("index %v: key %v is out of order with key %v",
+					idxKey, rec.Key(), next.Key())
+			}
+		}
 	}
+
+	return nil
 }
 
-// Pairs returns a slice of key-value pairs in the omap. By default, it iterates
-// over default (insertion) index. Use idxKey to iterate over other indexes.
-func (m *Omap[K, D]) Pairs(idxKey ...any) (pairs []Pair[K, D]) {
+// String returns records of ordered map in default (insertion) order formatted
+// as [key1:data1 key2:data2 ...]. Only the first 100 records are formatted,
+// the number of other records is added as ...(N more).
+func (m *Omap[K, D]) String() string {
 	m.RLock()
 	defer m.RUnlock()
 
+	var b strings.Builder
+	b.WriteString("[")
 	i := 0
-	pairs = make([]Pair[K, D], len(m.m))
-	for rec := m.Idx.first(idxKey...); rec != nil; rec = m.Idx.next(rec) {
-		pairs[i] = Pair[K, D]{Key: rec.Key(), Value: rec.Data()}
+	for rec := m.Idx.first(); rec != nil; rec = m.Idx.next(rec) {
+		if i == stringLimit {
+			fmt.Fprintf(&b, " ...(%d more)", len(m.m)-i)
+			break
+		}
+		if i > 0 {
+			b.WriteString(" ")
+		}
+		fmt.Fprint(&b, rec.Key(), ":", rec.Data())
 		i++
 	}
+	b.WriteString("]")
 
-	return
+	return b.String()
+}
+
+// Equal reports whether ordered maps m and other have the same keys in the same
+// default (insertion) order and data of each key is equal by function eq.
+//
+// Records of other map are copied under its RLock first and then compared
+// under RLock of map m, so maps are never locked both at once.
+func (m *Omap[K, D]) Equal(other *Omap[K, D], eq func(a, b D) bool) bool {
+	if m == other {
+		return true
+	}
+
+	// Get records of other map
+	other.RLock()
+	pairs := other.appendPairs(nil)
+	other.RUnlock()
+
+	m.RLock()
+	defer m.RUnlock()
+
+	if len(pairs) != len(m.m) {
+		return false
+	}
+	rec := m.Idx.first()
+	for _, pair := range pairs {
+		if rec.Key() != pair.Key || !eq(rec.Data(), pair.Value) {
+			return false
+		}
+		rec = m.Idx.next(rec)
+	}
+
+	return true
+}
+
+// EqualComparable reports whether ordered maps m and other are equal like
+// Equal with data compared by == operator.
+func EqualComparable[K, D comparable](m, other *Omap[K, D]) bool {
+	return m.Equal(other, func(a, b D) bool { return a == b })
 }
 
 // Records returns an iterator over the omap records. By default, it iterates
@@ -360,6 +1946,102 @@ func (m *Omap[K, D]) Records(idxKey ...any) iter.Seq2[K, D] {
 	return m.records(false, idxKey...)
 }
 
+// RecordsReverse returns an iterator over the omap records in reverse order,
+// from the last record to the first. By default, it iterates over default
+// (insertion) index. Use idxKey to iterate over other indexes.
+//
+// The iteration stops when the function passed to the iterator returns false.
+//
+// This function is safe for concurrent read access. RWmutex is locked by RLock.
+// Don't use other Omap methods which uses mutex inside iterator avoid deadlocks.
+func (m *Omap[K, D]) RecordsReverse(idxKey ...any) iter.Seq2[K, D] {
+	return func(yield func(K, D) bool) {
+		m.RLock()
+		defer m.RUnlock()
+
+		for rec := m.Idx.last(idxKey...); rec != nil; rec = m.Idx.prev(rec) {
+			if !yield(rec.Key(), rec.Data()) {
+				return
+			}
+		}
+	}
+}
+
+// Enumerate returns an iterator over zero-based positions and key-value pairs
+// of the omap records. By default, it iterates over default (insertion) index.
+// Use idxKey to iterate over other indexes.
+//
+// The iteration stops when the function passed to the iterator returns false.
+//
+// This function is safe for concurrent read access. RWmutex is locked by RLock.
+// Don't use other Omap methods which uses mutex inside iterator avoid deadlocks.
+func (m *Omap[K, D]) Enumerate(idxKey ...any) iter.Seq2[int, Pair[K, D]] {
+	return func(yield func(int, Pair[K, D]) bool) {
+		m.RLock()
+		defer m.RUnlock()
+
+		i := 0
+		for rec := m.Idx.first(idxKey...); rec != nil; rec = m.Idx.next(rec) {
+			if !yield(i, Pair[K, D]{Key: rec.Key(), Value: rec.Data()}) {
+				return
+			}
+			i++
+		}
+	}
+}
+
+// MergeSeq returns an iterator which merges records of ordered maps into one
+// sequence ordered by less function. Each map is iterated in order of its
+// default (insertion) index, which should be sorted by less, so the merge
+// keeps only one record of each map in memory. Records which are equal by
+// less are yielded in order of maps.
+//
+// Each map is locked by RLock during the iteration. Don't use Omap methods
+// which uses mutex inside iterator avoid deadlocks.
+func MergeSeq[K comparable, D any](less func(a, b Pair[K, D]) bool,
+	maps ...*Omap[K, D]) iter.Seq2[K, D] {
+
+	return func(yield func(K, D) bool) {
+
+		// Pull first record of each map
+		type head struct {
+			pair Pair[K, D]
+			next func() (K, D, bool)
+		}
+		var heads []*head
+		for _, m := range maps {
+			next, stop := iter.Pull2(m.Records())
+			defer stop()
+			if key, data, ok := next(); ok {
+				heads = append(heads, &head{Pair[K, D]{key, data}, next})
+			}
+		}
+
+		for len(heads) > 0 {
+
+			// Find head with least record
+			i := 0
+			for j := 1; j < len(heads); j++ {
+				if less(heads[j].pair, heads[i].pair) {
+					i = j
+				}
+			}
+
+			// Yield least record and pull next record of its map
+			h := heads[i]
+			if !yield(h.pair.Key, h.pair.Value) {
+				return
+			}
+			key, data, ok := h.next()
+			if !ok {
+				heads = slices.Delete(heads, i, i+1)
+				continue
+			}
+			h.pair = Pair[K, D]{key, data}
+		}
+	}
+}
+
 // RecordsWrite returns an iterator over the omap records. By default, it iterates
 // over default (insertion) index. Use idxKey to iterate over other indexes.
 //
@@ -371,6 +2053,298 @@ func (m *Omap[K, D]) RecordsWrite(idxKey ...any) iter.Seq2[K, D] {
 	return m.records(true, idxKey...)
 }
 
+// RecordsUnsafe returns an iterator over the omap records like Records, but
+// does not lock ordered map. Use it in sort functions with context (see
+// Index.FuncCtx) or while the map is locked. The map must not be modified
+// during the iteration.
+func (m *Omap[K, D]) RecordsUnsafe(idxKey ...any) iter.Seq2[K, D] {
+	return func(yield func(K, D) bool) {
+		for rec := m.Idx.first(idxKey...); rec != nil; rec = m.Idx.next(rec) {
+			if !yield(rec.Key(), rec.Data()) {
+				return
+			}
+		}
+	}
+}
+
+// Drain returns an iterator over the omap records which removes each record
+// from the map as it yields it. By default, it iterates over default
+// (insertion) index. Use idxKey to iterate over other indexes.
+//
+// The iteration stops when the function passed to the iterator returns false,
+// the record yielded last is removed and the rest records stay in the map.
+// When the iterator is fully consumed the map is empty.
+//
+// This function is safe for concurrent write access. RWmutex is locked by Lock.
+// Don't use other Omap methods which uses mutex inside iterator avoid deadlocks.
+func (m *Omap[K, D]) Drain(idxKey ...any) iter.Seq2[K, D] {
+	return func(yield func(K, D) bool) {
+		m.Lock()
+		defer m.Unlock()
+
+		var next *Record[K, D]
+		for rec := m.Idx.first(idxKey...); rec != nil; rec = next {
+			next = m.Idx.next(rec)
+			key, data := rec.Key(), rec.Data()
+			m.Idx.removeRecord(rec)
+			if !yield(key, data) {
+				return
+			}
+		}
+	}
+}
+
+// DrainChan returns channel which receives key-value pairs of the omap in
+// order of default (insertion) index, and function which stops the draining.
+// Each record is removed from the map after it was sent to the channel: after
+// it was received if buffer is 0, or after it was queued in the channel buffer
+// otherwise. The channel is closed when the map is empty or when the draining
+// is stopped.
+//
+// The channel has buffer of buffer size and is filled by goroutine. The
+// goroutine locks the map only to get and to remove each record and does not
+// hold the lock while sending to the channel, so the consumer may use any omap
+// methods. Records added to the back of the map during the draining are
+// drained too. Record which was not sent when the draining is stopped is kept
+// in the map, while up to buffer pairs queued in the channel are already
+// removed: read the channel until it is closed after stop to not lose them.
+func (m *Omap[K, D]) DrainChan(buffer int) (<-chan Pair[K, D], func()) {
+	ch := make(chan Pair[K, D], max(buffer, 0))
+	done := make(chan struct{})
+
+	go func() {
+		defer close(ch)
+		for {
+			// Check draining is stopped
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			// Get first record
+			m.RLock()
+			rec := m.Idx.first()
+			var pair Pair[K, D]
+			var v *recordValue[K, D]
+			if rec != nil {
+				pair = Pair[K, D]{Key: rec.Key(), Value: rec.Data()}
+				v = rec.value()
+			}
+			m.RUnlock()
+			if rec == nil {
+				return
+			}
+
+			// Send pair or stop
+			select {
+			case ch <- pair:
+			case <-done:
+				return
+			}
+
+			// Remove record if it was not removed during the send. The record
+			// value may be reused by records pool, so check the key too
+			m.Lock()
+			if r, ok := m.m[m.Idx.mapKey(pair.Key)]; ok && r.value() == v {
+				m.Idx.removeRecord(r)
+			}
+			m.Unlock()
+		}
+	}()
+
+	// Make stop function
+	var once sync.Once
+	stop := func() {
+		once.Do(func() { close(done) })
+	}
+
+	return ch, stop
+}
+
+// Positions returns zero-based positions of record with key in each index
+// list by index key, including the default (insertion) index with key 0.
+// Returns ok false if key does not exist.
+//
+// It is a diagnostic which walks all index lists, so it takes O(n) time for
+// each index.
+func (m *Omap[K, D]) Positions(key K) (positions map[any]int, ok bool) {
+	m.RLock()
+	defer m.RUnlock()
+
+	rec, ok := m.m[m.Idx.mapKey(key)]
+	if !ok {
+		return
+	}
+
+	// Find record element position in each index list
+	positions = make(map[any]int, len(m.lm))
+	for k, l := range m.lm {
+		i := 0
+		for el := l.Front(); el != nil; el = el.Next() {
+			if el == rec.value().els[k] {
+				positions[k] = i
+				break
+			}
+			i++
+		}
+	}
+
+	return
+}
+
+// RankByKey returns zero-based position of record with key in index by index
+// key. By default, it uses default (insertion) index. Returns ok false if key
+// does not exist or is not present in the index.
+//
+// The index list is walked from the record to the front, so it takes O(n)
+// time.
+func (m *Omap[K, D]) RankByKey(key K, idxKey ...any) (rank int, ok bool) {
+	m.RLock()
+	defer m.RUnlock()
+
+	rec, ok := m.m[m.Idx.mapKey(key)]
+	if !ok {
+		return
+	}
+
+	return m.Idx.rank(rec, idxKey...)
+}
+
+// Adjacent reports whether record b immediately follows record a (ab) and
+// whether record a immediately follows record b (ba) in index by index key.
+// Records may be got from any index of this map. Both results are false if
+// index does not exist or any record is nil or is not a record of this map.
+func (m *Omap[K, D]) Adjacent(idxKey any, a, b *Record[K, D]) (ab, ba bool) {
+	m.RLock()
+	defer m.RUnlock()
+
+	// Get records elements in the index list
+	var els [2]*list.Element
+	for i, rec := range []*Record[K, D]{a, b} {
+		rec, err := m.Idx.defaultRecord(rec)
+		if err != nil {
+			return
+		}
+		if els[i] = rec.value().els[idxKey]; els[i] == nil {
+			return
+		}
+	}
+
+	ab = els[0].Next() == els[1]
+	ba = els[1].Next() == els[0]
+
+	return
+}
+
+// Extremes returns first and last records of each index list by index key,
+// including the default (insertion) index with key 0. Records are nil if the
+// map is empty.
+//
+// It is a diagnostic which shows the min and max record of each index at a
+// glance.
+func (m *Omap[K, D]) Extremes() map[any][2]*Record[K, D] {
+	m.RLock()
+	defer m.RUnlock()
+
+	extremes := make(map[any][2]*Record[K, D], len(m.lm))
+	for k, l := range m.lm {
+		extremes[k] = [2]*Record[K, D]{
+			m.Idx.elementToRecord(l.Front()),
+			m.Idx.elementToRecord(l.Back()),
+		}
+	}
+
+	return extremes
+}
+
+// SuspendIndexes suspends additional indexes: new records are added to the
+// default (insertion) index only and additional indexes are not sorted. Use
+// it to speed up bulk load of large number of records and call ResumeIndexes
+// after the load.
+//
+// While indexes are suspended, iteration over additional indexes returns
+// invalid results.
+func (m *Omap[K, D]) SuspendIndexes() {
+	m.Lock()
+	defer m.Unlock()
+
+	m.suspended = true
+}
+
+// ResumeIndexes resumes additional indexes suspended by SuspendIndexes. It
+// fills every additional index list with all records and sorts it.
+func (m *Omap[K, D]) ResumeIndexes() {
+	m.Lock()
+	defer m.Unlock()
+
+	if !m.suspended {
+		return
+	}
+	m.suspended = false
+
+	// Fill additional index lists
+	for k := range m.lm {
+		if k != 0 {
+			m.Idx.fill(k)
+		}
+	}
+
+	// Sort additional index lists
+	var wg sync.WaitGroup
+	for k, l := range m.lm {
+		if k == 0 {
+			continue
+		}
+		wg.Go(func() {
+			m.Idx.sortStable(l, m.sm[k])
+		})
+	}
+	wg.Wait()
+}
+
+// SetDefaultComparator sets sort function of the default index. The default
+// index list is sorted by f immediately and is kept sorted on every Set, so
+// ForEach, Pairs and other methods return records in sort order without
+// passing index key. Set nil f to return to insertion order, the default
+// index keeps its current order then.
+//
+// While the default index is sorted by comparator, the Idx move methods
+// (MoveToFront, MoveBefore, etc.) return ErrDefaultIndexSorted because they
+// would break the sort order.
+func (m *Omap[K, D]) SetDefaultComparator(f SortIndexFunc[K, D]) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.sm[0] = m.Idx.tieBroken(f)
+	m.moves[0] = m.Idx.sortFunc(0, m.sm[0])
+}
+
+// SortLimitExceeded returns number of index sorts which exceeded the moves
+// limit of O(n log n) and were finished by stable merge sort since the map was
+// created.
+//
+// Growing value points to an inconsistent sort function (which reports two
+// records as mutually greater) or to heavy reorder of the index on every
+// sort, for example after changing indexed fields of many records directly.
+func (m *Omap[K, D]) SortLimitExceeded() int64 {
+	return m.sortLimits.Load()
+}
+
+// LastSortMoved returns number of records moves performed by the last sort
+// of index by index key, or 0 if the index was not sorted yet or does not
+// exist.
+//
+// It is a diagnostic: sort of stable data performs no moves, and number of
+// moves much greater than number of records points to an inconsistent sort
+// function (see SortLimitExceeded).
+func (m *Omap[K, D]) LastSortMoved(idxKey any) int {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.moves[idxKey]
+}
+
 // Refresh refreshes the index lists.
 //
 // The indexes automatically sorts when a new record was added or updated with
@@ -379,6 +2353,9 @@ func (m *Omap[K, D]) RecordsWrite(idxKey ...any) iter.Seq2[K, D] {
 // If you directly update the map data (D type) use this method to refresh the
 // index lists.
 //
+// The default index is sorted too when its sort function is set by
+// SetDefaultComparator, otherwise it keeps insertion order.
+//
 // You should use Lock or RLock to avoid concurrent access when changing the map
 // data directly.
 func (m *Omap[K, D]) Refresh() {
@@ -416,7 +2393,23 @@ func (m *Omap[K, D]) records(write bool, idxKey ...any) iter.Seq2[K, D] {
 }
 
 // set unsafe adds or updates record in ordered map by key with direction.
+// Index lists are sorted if existing record was updated, new record is
+// inserted to index lists at its sort position.
 func (m *Omap[K, D]) set(key K, data D, direction int) (err error) {
+	_, exists := m.m[m.Idx.mapKey(key)]
+	if err = m.put(key, data, direction); err != nil {
+		return
+	}
+	if exists {
+		m.Idx.sort()
+	}
+
+	return
+}
+
+// put unsafe adds or updates record in ordered map by key with direction like
+// set, but does not sort indexes after update of existing record.
+func (m *Omap[K, D]) put(key K, data D, direction int) (err error) {
 
 	// Check direction
 	if direction != back && direction != front {
@@ -424,16 +2417,25 @@ func (m *Omap[K, D]) set(key K, data D, direction int) (err error) {
 		return
 	}
 
-	// Check if key already exists. Update data and sort lists if exists
-	if rec, ok := m.m[key]; ok {
+	// Check unique indexes
+	rec, ok := m.m[m.Idx.mapKey(key)]
+	if err = m.Idx.checkUnique(m.Idx.recordKey(key), data, rec); err != nil {
+		return
+	}
+
+	// Check if key already exists. Update data if exists
+	if ok {
+		if m.fold != nil {
+			rec.value().Key = m.Idx.recordKey(key)
+		}
 		rec.Update(data)
-		m.Idx.sort()
+		m.Idx.changed(JournalSet, rec.Key(), data)
 		return
 	}
 
 	// Add new record to back or front of lists depending on direction and to
 	// the map
-	m.m[key] = m.Idx.insert(key, data, direction, nil)
+	m.m[m.Idx.mapKey(key)] = m.Idx.insert(key, data, direction, nil)
 
 	return
 }
@@ -0,0 +1,51 @@
+// Copyright 2025 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Builder of ordered map definition.
+
+package omap
+
+// Builder collects key-value pairs and builds ordered map with all indexes
+// sorted once. Use it for maps which are loaded once and then mostly read:
+// adding records to the builder does not sort indexes on each insert like Set
+// does.
+//
+// The built map is a usual ordered map, all its methods including writes are
+// available. Builder is not safe for concurrent use.
+type Builder[K comparable, D any] struct {
+	sorts []Index[K, D]
+	pairs []Pair[K, D]
+}
+
+// NewBuilder creates a new ordered map builder with index definitions and
+// options sorts which are passed to New on Build.
+func NewBuilder[K comparable, D any](sorts ...Index[K, D]) *Builder[K, D] {
+	return &Builder[K, D]{sorts: sorts}
+}
+
+// Add adds key-value pair to the builder. Pairs are added to the map in order
+// of Add calls, if key repeats its data is updated like Set does.
+func (b *Builder[K, D]) Add(key K, data D) {
+	b.pairs = append(b.pairs, Pair[K, D]{Key: key, Value: data})
+}
+
+// Build creates new ordered map with collected pairs and sorts every index
+// once. Build may be called several times, every call returns new map.
+func (b *Builder[K, D]) Build() (m *Omap[K, D], err error) {
+	m, err = New(b.sorts...)
+	if err != nil {
+		return
+	}
+
+	// Add records with suspended indexes and then sort indexes once
+	m.suspended = true
+	for _, pair := range b.pairs {
+		if err = m.set(pair.Key, pair.Value, back); err != nil {
+			return nil, err
+		}
+	}
+	m.ResumeIndexes()
+
+	return
+}
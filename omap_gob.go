@@ -0,0 +1,69 @@
+// Copyright 2025 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Gob encoding of ordered map definition.
+
+package omap
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+)
+
+// GobEncode encodes key-value pairs of ordered map in order of default
+// (insertion) index. Index definitions are functions and are not encoded.
+func (m *Omap[K, D]) GobEncode() ([]byte, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(m.appendPairs(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// GobDecode decodes key-value pairs encoded by GobEncode to ordered map. It
+// removes all records of the map and adds decoded records in encoded order.
+//
+// Zero Omap value may be used as decode destination, it gets the default
+// index only. Index definitions are not encoded, so to use sorted iteration
+// after decode, decode to map created by New with the same index definitions,
+// its indexes are sorted by decode. If decoded record violates unique index
+// (see Index.Unique) it returns ErrUniqueConstraint and leaves the map empty.
+func (m *Omap[K, D]) GobDecode(data []byte) (err error) {
+	var pairs []Pair[K, D]
+	if err = gob.NewDecoder(bytes.NewReader(data)).Decode(&pairs); err != nil {
+		return
+	}
+
+	return m.load(pairs)
+}
+
+// load removes all records of ordered map, adds records from pairs and sorts
+// indexes under one Lock. It makes zero ordered map before loading. It returns
+// error of the first record which can't be added, for example which violates
+// unique index, and leaves the map empty then.
+func (m *Omap[K, D]) load(pairs []Pair[K, D]) (err error) {
+	if m.RWMutex == nil {
+		m.init()
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	m.clear()
+	for _, pair := range pairs {
+		if err = m.put(pair.Key, pair.Value, back); err != nil {
+			m.clear()
+			return fmt.Errorf("key %v: %w", pair.Key, err)
+		}
+	}
+	m.Idx.sort()
+
+	return
+}
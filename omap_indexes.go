@@ -9,6 +9,8 @@ package omap
 import (
 	"container/list"
 	"fmt"
+	"math/bits"
+	"slices"
 	"sync"
 )
 
@@ -38,7 +40,7 @@ func (in *Indexes[K, D]) Prev(rec *Record[K, D]) *Record[K, D] {
 	in.RLock()
 	defer in.RUnlock()
 
-	return in.elementToRecord(rec.element().Prev())
+	return in.prev(rec)
 }
 
 // Last gets last record from ordered map or nil if the list is empty.
@@ -46,17 +48,14 @@ func (in *Indexes[K, D]) Last(idxKeys ...any) *Record[K, D] {
 	in.RLock()
 	defer in.RUnlock()
 
-	// Get index list by key
-	list, ok := in.getList(idxKeys...)
-	if !ok {
-		return nil
-	}
-
-	return in.elementToRecord(list.Back())
+	return in.last(idxKeys...)
 }
 
 // InsertBefore inserts record before element. Returns ErrKeyAllreadySet if key
-// already exists.
+// already exists, ErrRecordNotFound if mark is nil, ErrForeignRecord if mark
+// is not a record of this map, ErrDefaultIndexSorted if default index is
+// sorted by comparator (see SetDefaultComparator) and ErrUniqueConstraint if
+// data violates unique index.
 func (in *Indexes[K, D]) InsertBefore(key K, data D, mark *Record[K, D]) (
 	err error) {
 
@@ -64,19 +63,38 @@ func (in *Indexes[K, D]) InsertBefore(key K, data D, mark *Record[K, D]) (
 	defer in.Unlock()
 
 	// Check if key already exists
-	if _, ok := in.m[key]; ok {
+	if _, ok := in.m[in.mapKey(key)]; ok {
 		err = ErrKeyAllreadySet
 		return
 	}
 
+	// Get mark record of default index
+	if mark, err = in.defaultRecord(mark); err != nil {
+		return
+	}
+
+	// Return error if default index is sorted by comparator
+	if in.sm[0] != nil {
+		err = ErrDefaultIndexSorted
+		return
+	}
+
+	// Check unique indexes
+	if err = in.checkUnique(in.recordKey(key), data, nil); err != nil {
+		return
+	}
+
 	// Add new record before selected
-	in.m[key] = in.insert(key, data, before, mark)
+	in.m[in.mapKey(key)] = in.insert(key, data, before, mark)
 
 	return
 }
 
 // InsertAfter inserts record after element. Returns ErrKeyAllreadySet if key
-// already exists.
+// already exists, ErrRecordNotFound if mark is nil, ErrForeignRecord if mark
+// is not a record of this map, ErrDefaultIndexSorted if default index is
+// sorted by comparator (see SetDefaultComparator) and ErrUniqueConstraint if
+// data violates unique index.
 func (in *Indexes[K, D]) InsertAfter(key K, data D, mark *Record[K, D]) (
 	err error) {
 
@@ -84,26 +102,48 @@ func (in *Indexes[K, D]) InsertAfter(key K, data D, mark *Record[K, D]) (
 	defer in.Unlock()
 
 	// Check if key already exists
-	if _, ok := in.m[key]; ok {
+	if _, ok := in.m[in.mapKey(key)]; ok {
 		err = ErrKeyAllreadySet
 		return
 	}
 
-	// Add new record before selected
-	in.m[key] = in.insert(key, data, after, mark)
+	// Get mark record of default index
+	if mark, err = in.defaultRecord(mark); err != nil {
+		return
+	}
+
+	// Return error if default index is sorted by comparator
+	if in.sm[0] != nil {
+		err = ErrDefaultIndexSorted
+		return
+	}
+
+	// Check unique indexes
+	if err = in.checkUnique(in.recordKey(key), data, nil); err != nil {
+		return
+	}
+
+	// Add new record after selected
+	in.m[in.mapKey(key)] = in.insert(key, data, after, mark)
 
 	return
 }
 
 // MoveToBack moves record to the back of ordered map. It returns ErrRecordNotFound
-// if input record is nil.
+// if input record is nil and ErrForeignRecord if it is not a record of this map.
 func (in *Indexes[K, D]) MoveToBack(rec *Record[K, D]) (err error) {
 	in.Lock()
 	defer in.Unlock()
 
-	// Return error if input record is nil
-	if rec == nil {
-		err = ErrRecordNotFound
+	// Get record of default index, return error if input record is nil or
+	// is not a record of this map
+	if rec, err = in.defaultRecord(rec); err != nil {
+		return
+	}
+
+	// Return error if default index is sorted by comparator
+	if in.sm[0] != nil {
+		err = ErrDefaultIndexSorted
 		return
 	}
 
@@ -114,14 +154,20 @@ func (in *Indexes[K, D]) MoveToBack(rec *Record[K, D]) (err error) {
 }
 
 // MoveToFront moves record to the front of ordered map. It returns ErrRecordNotFound
-// if input record is nil.
+// if input record is nil and ErrForeignRecord if it is not a record of this map.
 func (in *Indexes[K, D]) MoveToFront(rec *Record[K, D]) (err error) {
 	in.Lock()
 	defer in.Unlock()
 
-	// Return error if input record is nil
-	if rec == nil {
-		err = ErrRecordNotFound
+	// Get record of default index, return error if input record is nil or
+	// is not a record of this map
+	if rec, err = in.defaultRecord(rec); err != nil {
+		return
+	}
+
+	// Return error if default index is sorted by comparator
+	if in.sm[0] != nil {
+		err = ErrDefaultIndexSorted
 		return
 	}
 
@@ -130,15 +176,63 @@ func (in *Indexes[K, D]) MoveToFront(rec *Record[K, D]) (err error) {
 	return
 }
 
+// MoveMatchingToFront moves all records for which pred returns true to the
+// front of ordered map keeping their relative order. It returns number of
+// moved records. Nothing is moved if the default index is sorted by
+// comparator.
+//
+// Function pred must not call omap methods which use mutex avoid deadlocks.
+func (in *Indexes[K, D]) MoveMatchingToFront(pred func(key K, data D) bool) (
+	moved int) {
+
+	in.Lock()
+	defer in.Unlock()
+
+	// Skip if default index is sorted by comparator
+	if in.sm[0] != nil {
+		return
+	}
+
+	// Move matching records in forward order: the first one to the front and
+	// each next one after the previously moved record
+	var mark, next *list.Element
+	for el := in.lm[0].Front(); el != nil; el = next {
+		next = el.Next()
+		rec := in.elementToRecord(el)
+		if !pred(rec.Key(), rec.Data()) {
+			continue
+		}
+		if mark == nil {
+			in.lm[0].MoveToFront(el)
+		} else {
+			in.lm[0].MoveAfter(el, mark)
+		}
+		mark = el
+		moved++
+	}
+
+	return
+}
+
 // MoveBefore moves record rec to the new position before mark record. It returns
-// ErrRecordNotFound if input record or mark record is nil.
+// ErrRecordNotFound if input record or mark record is nil and ErrForeignRecord
+// if any of them is not a record of this map.
 func (in *Indexes[K, D]) MoveBefore(rec, mark *Record[K, D]) (err error) {
 	in.Lock()
 	defer in.Unlock()
 
-	// Return error if input record or mark record is nil
-	if rec == nil || mark == nil {
-		err = ErrRecordNotFound
+	// Get records of default index, return error if input record or mark
+	// record is nil or is not a record of this map
+	if rec, err = in.defaultRecord(rec); err != nil {
+		return
+	}
+	if mark, err = in.defaultRecord(mark); err != nil {
+		return
+	}
+
+	// Return error if default index is sorted by comparator
+	if in.sm[0] != nil {
+		err = ErrDefaultIndexSorted
 		return
 	}
 
@@ -149,14 +243,15 @@ func (in *Indexes[K, D]) MoveBefore(rec, mark *Record[K, D]) (err error) {
 }
 
 // MoveUp moves record rec to the new position before previous record. It returns
-// ErrRecordNotFound if input record is nil.
+// ErrRecordNotFound if input record is nil or is the first record and
+// ErrForeignRecord if it is not a record of this map.
 func (in *Indexes[K, D]) MoveUp(rec *Record[K, D]) (err error) {
 	in.Lock()
 	defer in.Unlock()
 
-	// Return error if input record is nil
-	if rec == nil {
-		err = ErrRecordNotFound
+	// Get record of default index, return error if input record is nil or
+	// is not a record of this map
+	if rec, err = in.defaultRecord(rec); err != nil {
 		return
 	}
 
@@ -167,21 +262,77 @@ func (in *Indexes[K, D]) MoveUp(rec *Record[K, D]) (err error) {
 		return
 	}
 
+	// Return error if default index is sorted by comparator
+	if in.sm[0] != nil {
+		err = ErrDefaultIndexSorted
+		return
+	}
+
 	// Move record
 	in.lm[0].MoveBefore(rec.element(), mark)
 
 	return
 }
 
+// MoveBy moves record rec by offset positions in the default (insertion)
+// index: toward the front if offset is negative and toward the back if offset
+// is positive. The record stops at the front or back if offset exceeds the
+// number of records before or after it. It returns ErrRecordNotFound if input
+// record is nil and ErrForeignRecord if it is not a record of this map.
+func (in *Indexes[K, D]) MoveBy(rec *Record[K, D], offset int) (err error) {
+	in.Lock()
+	defer in.Unlock()
+
+	// Get record of default index, return error if input record is nil or
+	// is not a record of this map
+	if rec, err = in.defaultRecord(rec); err != nil {
+		return
+	}
+
+	// Return error if default index is sorted by comparator
+	if in.sm[0] != nil {
+		err = ErrDefaultIndexSorted
+		return
+	}
+
+	// Find mark element and move record
+	el := rec.element()
+	mark := el
+	switch {
+	case offset < 0:
+		for ; offset < 0 && mark.Prev() != nil; offset++ {
+			mark = mark.Prev()
+		}
+		in.lm[0].MoveBefore(el, mark)
+	case offset > 0:
+		for ; offset > 0 && mark.Next() != nil; offset-- {
+			mark = mark.Next()
+		}
+		in.lm[0].MoveAfter(el, mark)
+	}
+
+	return
+}
+
 // MoveAfter moves record rec to the new position after mark record. It returns
-// ErrRecordNotFound if input record or mark record is nil.
+// ErrRecordNotFound if input record or mark record is nil and ErrForeignRecord
+// if any of them is not a record of this map.
 func (in *Indexes[K, D]) MoveAfter(rec, mark *Record[K, D]) (err error) {
 	in.Lock()
 	defer in.Unlock()
 
-	// Return error if input record or mark record is nil
-	if rec == nil || mark == nil {
-		err = ErrRecordNotFound
+	// Get records of default index, return error if input record or mark
+	// record is nil or is not a record of this map
+	if rec, err = in.defaultRecord(rec); err != nil {
+		return
+	}
+	if mark, err = in.defaultRecord(mark); err != nil {
+		return
+	}
+
+	// Return error if default index is sorted by comparator
+	if in.sm[0] != nil {
+		err = ErrDefaultIndexSorted
 		return
 	}
 
@@ -191,6 +342,238 @@ func (in *Indexes[K, D]) MoveAfter(rec, mark *Record[K, D]) (err error) {
 	return
 }
 
+// Range returns key-value pairs of records which keys are in the inclusive
+// range from lo to hi in index order. By default, it uses default (insertion)
+// index. Use idxKey to use other indexes. The index must be sorted by keys in
+// ascending order (for example by CompareByKey): its sort function is called
+// with probe records which have lo or hi key and zero data. It returns nil if
+// index does not exist or has no sort function.
+//
+// The index list is scanned forward from the front to the first record after
+// hi, so it takes O(n) time in the worst case.
+func (in *Indexes[K, D]) Range(lo, hi K, idxKey ...any) (pairs []Pair[K, D]) {
+	in.RLock()
+	defer in.RUnlock()
+
+	// Get index sort function
+	var key any = 0
+	if len(idxKey) > 0 {
+		key = idxKey[0]
+	}
+	f := in.sm[key]
+	if f == nil {
+		return
+	}
+
+	// Collect records from the first not less than lo to the last not greater
+	// than hi
+	var zero D
+	probeLo, probeHi := NewRecord(lo, zero), NewRecord(hi, zero)
+	pairs = []Pair[K, D]{}
+	for rec := in.first(key); rec != nil; rec = in.next(rec) {
+		k := rec.Key()
+		if k != lo && f(rec, probeLo) < 0 {
+			continue
+		}
+		if k != hi && f(rec, probeHi) > 0 {
+			break
+		}
+		pairs = append(pairs, Pair[K, D]{Key: k, Value: rec.Data()})
+	}
+
+	return
+}
+
+// Min returns the record which is the least by sort function of index by
+// index key. By default, it uses default (insertion) index. Returns ok false
+// if index does not exist, has no sort function or is empty.
+//
+// All records are compared with the sort function regardless of the index
+// list order, so it takes O(n) time.
+func (in *Indexes[K, D]) Min(idxKey ...any) (rec *Record[K, D], ok bool) {
+	return in.extreme(-1, idxKey...)
+}
+
+// Max returns the record which is the greatest by sort function of index by
+// index key like Min.
+func (in *Indexes[K, D]) Max(idxKey ...any) (rec *Record[K, D], ok bool) {
+	return in.extreme(1, idxKey...)
+}
+
+// extreme returns the least (sign is -1) or the greatest (sign is 1) record
+// by sort function of index by index key.
+func (in *Indexes[K, D]) extreme(sign int, idxKey ...any) (rec *Record[K, D],
+	ok bool) {
+
+	in.RLock()
+	defer in.RUnlock()
+
+	// Get index sort function
+	var key any = 0
+	if len(idxKey) > 0 {
+		key = idxKey[0]
+	}
+	f := in.sm[key]
+	if f == nil {
+		return
+	}
+
+	// Find the first record which no other record exceeds
+	for r := in.first(key); r != nil; r = in.next(r) {
+		if rec == nil || f(r, rec)*sign > 0 {
+			rec = r
+		}
+	}
+
+	return rec, rec != nil
+}
+
+// Rank returns zero-based position of record rec in index by index key. By
+// default, it uses default (insertion) index. Returns ok false if rec is nil,
+// is not a record of this map or is not present in the index.
+//
+// The index list is walked from the record to the front, so it takes O(n)
+// time.
+func (in *Indexes[K, D]) Rank(rec *Record[K, D], idxKey ...any) (rank int,
+	ok bool) {
+
+	in.RLock()
+	defer in.RUnlock()
+
+	return in.rank(rec, idxKey...)
+}
+
+// rank returns zero-based position of record rec in index by index key.
+// Unsafe (does not lock).
+func (in *Indexes[K, D]) rank(rec *Record[K, D], idxKey ...any) (rank int,
+	ok bool) {
+
+	// Get record of default index
+	rec, err := in.defaultRecord(rec)
+	if err != nil {
+		return
+	}
+
+	// Get record element of the index list
+	var key any = 0
+	if len(idxKey) > 0 {
+		key = idxKey[0]
+	}
+	el, ok := rec.value().els[key]
+	if !ok {
+		return
+	}
+
+	// Count elements before the record element
+	for el = el.Prev(); el != nil; el = el.Prev() {
+		rank++
+	}
+
+	return
+}
+
+// AddIndex adds additional index to ordered map, fills the index list with all
+// records and sorts it once under one Lock, so other goroutines never see
+// partially filled index. It returns ErrIncorrectIndexKey if index key is the
+// default (insertion) index key 0, if index already exists or if index key is
+// not hashable, and ErrUniqueConstraint if index is unique and existing
+// records violate it. The index is not added if error is returned.
+//
+// Existing records of suspended map are not checked by unique index.
+func (in *Indexes[K, D]) AddIndex(idx Index[K, D]) (err error) {
+	in.Lock()
+	defer in.Unlock()
+
+	// Check index is not an option
+	if idx.option != nil {
+		err = ErrIncorrectIndexKey
+		return
+	}
+
+	// Add index and tie-break function
+	m := (*Omap[K, D])(in)
+	if err = m.addIndex(idx); err != nil {
+		return
+	}
+	in.sm[idx.Key] = in.tieBroken(in.sm[idx.Key])
+
+	// Fill and sort index list, suspended index is filled on resume
+	if in.suspended {
+		return
+	}
+	in.rebuild(idx.Key)
+
+	// Check unique index: equal records are adjacent in sorted list
+	f := in.unique[idx.Key]
+	if f == nil {
+		return
+	}
+	for rec := in.first(idx.Key); rec != nil; rec = in.next(rec) {
+		if next := in.next(rec); next != nil && f(rec, next) == 0 {
+			in.removeIndex(idx.Key)
+			err = fmt.Errorf("%w: index %v", ErrUniqueConstraint, idx.Key)
+			return
+		}
+	}
+
+	return
+}
+
+// RemoveIndex removes additional index by index key from ordered map, so its
+// sort function is not called any more. It returns ErrIncorrectIndexKey if
+// idxKey is the default (insertion) index key 0 or if index does not exist.
+//
+// Iteration methods called with key of removed index (like ForEach or Pairs)
+// iterate no records, the same as with any unknown index key.
+func (in *Indexes[K, D]) RemoveIndex(idxKey any) (err error) {
+	in.Lock()
+	defer in.Unlock()
+
+	// Check index key
+	if _, ok := in.lm[idxKey]; !ok || idxKey == 0 {
+		err = ErrIncorrectIndexKey
+		return
+	}
+	in.removeIndex(idxKey)
+
+	return
+}
+
+// removeIndex removes index list elements from records and index definition
+// by index key. Unsafe (does not lock).
+func (in *Indexes[K, D]) removeIndex(idxKey any) {
+	for el := in.lm[0].Front(); el != nil; el = el.Next() {
+		delete(in.elementToRecord(el).value().els, idxKey)
+	}
+	delete(in.lm, idxKey)
+	delete(in.sm, idxKey)
+	delete(in.ctxFuncs, idxKey)
+	delete(in.unique, idxKey)
+	delete(in.moves, idxKey)
+}
+
+// defaultRecord returns record of the default (insertion) index for record
+// rec got from any index of this map. It returns ErrRecordNotFound if rec is
+// nil and ErrForeignRecord if rec is not a record of this map (was removed or
+// belongs to other map). Unsafe (does not lock).
+func (in *Indexes[K, D]) defaultRecord(rec *Record[K, D]) (*Record[K, D], error) {
+	if rec == nil {
+		return nil, ErrRecordNotFound
+	}
+
+	// Check that record value belongs to this map
+	v := rec.value()
+	if v == nil {
+		return nil, ErrForeignRecord
+	}
+	r, ok := in.m[in.mapKey(v.Key)]
+	if !ok || r.value() != v {
+		return nil, ErrForeignRecord
+	}
+
+	return r, nil
+}
+
 // First gets first record from ordered map or nil if map is empty or incorrect
 // index is passed. Unsafe for concurrent read access.
 func (in *Indexes[K, D]) first(idxKeys ...any) *Record[K, D] {
@@ -215,9 +598,42 @@ func (in *Indexes[K, D]) next(rec *Record[K, D]) *Record[K, D] {
 	return in.elementToRecord(rec.element().Next())
 }
 
+// last gets last record from ordered map or nil if map is empty or incorrect
+// index is passed. Unsafe for concurrent read access.
+func (in *Indexes[K, D]) last(idxKeys ...any) *Record[K, D] {
+	// Get index list by key
+	list, ok := in.getList(idxKeys...)
+	if !ok {
+		return nil
+	}
+
+	return in.elementToRecord(list.Back())
+}
+
+// prev gets previous record from ordered map or nil if there is first record
+// or input record is nil. Unsafe for concurrent read access.
+func (in *Indexes[K, D]) prev(rec *Record[K, D]) *Record[K, D] {
+
+	// Return nil if input record is nil
+	if rec == nil {
+		return nil
+	}
+
+	return in.elementToRecord(rec.element().Prev())
+}
+
 // sortFunc sorts records in list by index key using sort function.
 // Unsafe (does not lock).
-func (in *Indexes[K, D]) sortFunc(idxKey any, f func(rec, next *Record[K, D]) int) {
+//
+// The records are sorted by moving each record forward to its position. The
+// number of moves is limited to O(n log n) so an inconsistent sort function
+// (which reports two records as mutually greater) can't make the sort thrash.
+// If the limit is exceeded the list is sorted by stable merge sort which
+// always finishes, and the SortLimitExceeded counter is incremented.
+//
+// It returns number of moves performed by the sort.
+func (in *Indexes[K, D]) sortFunc(idxKey any, f func(rec, next *Record[K, D]) int) (
+	moves int) {
 
 	// Skip if f function not set
 	if f == nil {
@@ -231,10 +647,48 @@ func (in *Indexes[K, D]) sortFunc(idxKey any, f func(rec, next *Record[K, D]) in
 	}
 
 	// Sort records in list
+	var limit = l.Len() * (bits.Len(uint(l.Len())) + 1)
 	var next *list.Element
 	for el := l.Front(); el != nil; el = next {
 		next = el.Next()
-		in.sortRecord(idxKey, el, f)
+		if !in.sortRecord(idxKey, el, f) {
+			continue
+		}
+
+		// Sort list by merge sort if moves limit exceeded
+		if moves++; moves > limit {
+			in.sortLimits.Add(1)
+			if printMode {
+				fmt.Printf("Sort    idx: %v, moves limit %d exceeded\n",
+					idxKey, limit)
+			}
+			in.sortStable(l, f)
+			return
+		}
+	}
+
+	return
+}
+
+// sortStable sorts records in list using stable merge sort.
+func (in *Indexes[K, D]) sortStable(l *list.List, f func(rec,
+	next *Record[K, D]) int) {
+
+	// Skip if f function not set
+	if f == nil {
+		return
+	}
+
+	// Get list records
+	recs := make([]*Record[K, D], 0, l.Len())
+	for el := l.Front(); el != nil; el = el.Next() {
+		recs = append(recs, in.elementToRecord(el))
+	}
+
+	// Sort records and rearrange list elements
+	slices.SortStableFunc(recs, f)
+	for _, rec := range recs {
+		l.MoveToBack(rec.element())
 	}
 }
 
@@ -290,67 +744,234 @@ const (
 	after
 )
 
-// insert adds new record to ordered map.
+// insert adds new record to ordered map index lists. Unsafe (does not lock).
 //
 //	direction:
 //	0 - back,
 //	1 - front,
 //	2 - insert before
 //	3 - insert after
+//
+// The direction is used for not sorted default (insertion) index. The record
+// is inserted to sorted index lists at its sort position (see insertSorted),
+// so index lists are not sorted after insert.
 func (in *Indexes[K, D]) insert(key K, data D, direction int,
 	mark *Record[K, D]) (rec *Record[K, D]) {
 
 	// Create new record and it to basic(insertion) list
-	v := &recordValue[K, D]{Key: key, Data: data}
+	key = in.recordKey(key)
+	v := in.newValue(key, data)
 
 	// Add element to basic(insertion) list
-	switch direction {
-	case 0:
+	switch {
+	case in.sm[0] != nil:
+		rec = in.elementToRecord(in.insertSorted(in.lm[0], in.sm[0], v))
+	case direction == back:
 		rec = in.elementToRecord(in.lm[0].PushBack(v))
-	case 1:
+	case direction == front:
 		rec = in.elementToRecord(in.lm[0].PushFront(v))
-	case 2:
+	case direction == before:
 		rec = in.elementToRecord(in.lm[0].InsertBefore(v, mark.element()))
-	case 3:
+	case direction == after:
 		rec = in.elementToRecord(in.lm[0].InsertAfter(v, mark.element()))
 	}
+	v.els[0] = rec.element()
 
-	// Add element to back of additional index lists and sort this lists
-	var wg sync.WaitGroup
+	// Add element to additional index lists
 	for k := range in.lm {
-		// Skip basic insertion list
-		if k == 0 {
+		// Skip basic insertion list and suspended indexes
+		if k == 0 || in.suspended {
 			continue
 		}
+		v.els[k] = in.insertSorted(in.lm[k], in.sm[k], v)
+	}
 
-		// Add element to the top of list
-		in.lm[k].PushFront(v)
+	in.changed(JournalSet, key, data)
 
-		// Sort list
-		wg.Go(func() {
-			in.sortFunc(k, in.sm[k])
-		})
+	return
+}
+
+// insertSorted adds record value v to sorted list l before the first element
+// which is not less than v by sort function f, so the list stays sorted. It
+// takes one walk of the list, or O(1) time if v is greater than the last
+// element. The value is added to the front of the list if f is nil. Unsafe
+// (does not lock).
+func (in *Indexes[K, D]) insertSorted(l *list.List, f SortIndexFunc[K, D],
+	v *recordValue[K, D]) (el *list.Element) {
+
+	// Skip if f function not set
+	if f == nil {
+		return l.PushFront(v)
+	}
+
+	// Push value to the back and keep it there if it is greater than last
+	// element
+	el = l.PushBack(v)
+	rec := in.elementToRecord(el)
+	if prev := el.Prev(); prev == nil || f(rec, in.elementToRecord(prev)) > 0 {
+		return
+	}
+
+	// Move value before the first element which is not less than it
+	for mark := l.Front(); mark != el; mark = mark.Next() {
+		if f(rec, in.elementToRecord(mark)) <= 0 {
+			l.MoveBefore(el, mark)
+			in.printMove(nil, true, el, mark)
+			break
+		}
+	}
+
+	return
+}
+
+// removeRecord removes record from all index lists and from the data map,
+// writes the change to journal and returns record value to pool. Unsafe (does
+// not lock).
+//
+// It is the only path to remove single record: Del, DelLast, eviction and
+// other removal methods must use it, so every removal updates all bookkeeping
+// of the map consistently.
+func (in *Indexes[K, D]) removeRecord(rec *Record[K, D]) {
+	v := rec.value()
+	if v == nil {
+		return
+	}
+
+	// Remove record elements from all index lists
+	for k, el := range v.els {
+		if l, ok := in.lm[k]; ok {
+			l.Remove(el)
+		}
+	}
+
+	// Remove key from map
+	delete(in.m, in.mapKey(v.Key))
+	in.changed(JournalDel, v.Key, v.Data)
+
+	// Return record value to pool
+	if in.pool != nil {
+		*v = recordValue[K, D]{els: v.els}
+		clear(v.els)
+		in.pool.Put(v)
+	}
+}
+
+// checkUnique returns ErrUniqueConstraint if record with key and data is equal
+// by sort function of any unique index to other record than self. Set self
+// to the updated record, or to nil for new record. Unsafe (does not lock).
+//
+// The whole index list is scanned because batch methods update records
+// before sorting indexes once, so the list may be unsorted. While indexes are
+// suspended their lists are empty, so records of the default index are
+// scanned.
+func (in *Indexes[K, D]) checkUnique(key K, data D, self *Record[K, D]) error {
+	if len(in.unique) == 0 {
+		return nil
 	}
-	wg.Wait()
 
+	probe := NewRecord(key, data)
+	for k, f := range in.unique {
+		listKey := k
+		if in.suspended {
+			listKey = 0
+		}
+		for rec := in.first(listKey); rec != nil; rec = in.next(rec) {
+			if self != nil && rec.value() == self.value() {
+				continue
+			}
+			if f(rec, probe) == 0 {
+				return fmt.Errorf("%w: index %v", ErrUniqueConstraint, k)
+			}
+		}
+	}
+
+	return nil
+}
+
+// newValue creates new record value or gets it from pool if records pool is
+// enabled.
+func (in *Indexes[K, D]) newValue(key K, data D) (v *recordValue[K, D]) {
+	if in.pool != nil {
+		v = in.pool.Get().(*recordValue[K, D])
+		v.Key, v.Data = key, data
+		return
+	}
+
+	v = &recordValue[K, D]{Key: key, Data: data}
+	v.els = make(map[any]*list.Element, len(in.lm))
 	return
 }
 
-// sort sorts all additional index lists.
+// rebuild discards index list by index key, pushes all records of the map to
+// it in order of default (insertion) index and sorts it. Unsafe (does not
+// lock).
+func (in *Indexes[K, D]) rebuild(idxKey any) {
+	in.fill(idxKey)
+	in.sortStable(in.lm[idxKey], in.sm[idxKey])
+}
+
+// fill discards index list by index key and pushes all records of the map to
+// it in order of default (insertion) index. Unsafe (does not lock).
+func (in *Indexes[K, D]) fill(idxKey any) {
+	l := in.lm[idxKey].Init()
+	for el := in.lm[0].Front(); el != nil; el = el.Next() {
+		v := in.elementToRecord(el).value()
+		v.els[idxKey] = l.PushBack(v)
+	}
+}
+
+// sort sorts all additional index lists and the default index list if it
+// is sorted by comparator (see SetDefaultComparator).
 func (in *Indexes[K, D]) sort() {
 	var wg sync.WaitGroup
+	var keys []any
 	for k := range in.sm {
-		// Skip basic insertion list
-		if k == 0 {
+		// Skip not sorted basic insertion list and suspended indexes
+		if in.sm[k] == nil || (k != 0 && in.suspended) {
 			continue
 		}
+		keys = append(keys, k)
+	}
 
-		// Sort list
+	// Sort lists and save number of moves of each sort
+	moves := make([]int, len(keys))
+	for i, k := range keys {
 		wg.Go(func() {
-			in.sortFunc(k, in.sm[k])
+			moves[i] = in.sortFunc(k, in.sm[k])
 		})
 	}
 	wg.Wait()
+	for i, k := range keys {
+		in.moves[k] = moves[i]
+	}
+}
+
+// tieBroken returns sort function f combined with the tie-break function if
+// both are set, or f otherwise.
+func (in *Indexes[K, D]) tieBroken(f SortIndexFunc[K, D]) SortIndexFunc[K, D] {
+	if f == nil || in.tieBreak == nil {
+		return f
+	}
+	return Combine(f, in.tieBreak)
+}
+
+// mapKey returns key of data map for key. It is folded record key if the map
+// has key fold function or the record key itself.
+func (in *Indexes[K, D]) mapKey(key K) K {
+	key = in.recordKey(key)
+	if in.fold != nil {
+		return in.fold(key)
+	}
+	return key
+}
+
+// recordKey returns key kept in record for key. It is transformed key if the
+// map has key transform function or the key itself.
+func (in *Indexes[K, D]) recordKey(key K) K {
+	if in.transform != nil {
+		return in.transform(key)
+	}
+	return key
 }
 
 // getList gets list from ordered map by index key. If index key is not set,
@@ -369,28 +990,6 @@ func (in *Indexes[K, D]) getList(idxKeys ...any) (list *list.List, ok bool) {
 	return
 }
 
-// checkPair checks if record pair is already sorted.
-//
-// The function takes pair of records keys prepared to compare and checks if
-// this pair is already sorted. If pair is sorted, the function returns true,
-// otherwise it returns false.
-func (in *Indexes[K, D]) checkPair(sorted map[any]any, k1, k2 any) bool {
-	// Create array of sorted pair
-	sk := []struct{ k1, k2 any }{{k1, k2}, {k2, k1}}
-
-	// Check if pair is already sorted
-	for _, k := range sk {
-		if _, ok := sorted[k]; ok {
-			return true
-		}
-	}
-
-	// Add pair to sorted map
-	sorted[sk[0]] = nil
-
-	return false
-}
-
 // Print move records. To enable print move set printMove variable to true.
 func (in *Indexes[K, D]) printMove(idxKey any, before bool, el, next *list.Element) {
 
@@ -0,0 +1,51 @@
+// Copyright 2025 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Journal of ordered map changes definition.
+
+package omap
+
+import "io"
+
+// Journal operations passed to journal encoder function.
+const (
+	JournalSet   = "set"   // Record added or updated
+	JournalDel   = "del"   // Record removed
+	JournalClear = "clear" // All records removed, key and data are zero
+)
+
+// journal writes ordered map changes to writer.
+type journal[K comparable, D any] struct {
+	w   io.Writer
+	enc func(op string, key K, data D) []byte
+}
+
+// Journal starts writing every change of the ordered map to writer w. Function
+// enc encodes the change operation (JournalSet, JournalDel or JournalClear),
+// key and data to bytes written to w. Call Journal with nil writer or nil enc
+// to stop journaling.
+//
+// Changes are encoded and written synchronously under the ordered map Lock,
+// so slow writer slows down every ordered map change. Use buffered writer to
+// reduce this cost. Write errors are ignored, the writer should handle them.
+// Data changed directly in records (with Record.Update or by pointer) is not
+// journaled.
+func (m *Omap[K, D]) Journal(w io.Writer, enc func(op string, key K, data D) []byte) {
+	m.Lock()
+	defer m.Unlock()
+
+	if w == nil || enc == nil {
+		m.jrn = nil
+		return
+	}
+	m.jrn = &journal[K, D]{w, enc}
+}
+
+// write encodes and writes change to journal writer if journal is started.
+func (j *journal[K, D]) write(op string, key K, data D) {
+	if j == nil {
+		return
+	}
+	j.w.Write(j.enc(op, key, data))
+}
@@ -0,0 +1,216 @@
+// Copyright 2025 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// JSON encoding of ordered map definition.
+
+package omap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+)
+
+// jsonPair is a key-value pair of ordered map encoded to JSON array.
+type jsonPair[K comparable, D any] struct {
+	Key   K `json:"key"`
+	Value D `json:"value"`
+}
+
+// DecodeJSONObject decodes JSON object to ordered map which keeps object fields
+// in document order. Fields values are kept as raw JSON to be decoded later.
+// If the object has duplicate fields, the last value wins and the field keeps
+// position of its first occurrence. Data after the object end (other than
+// white space) is an error.
+func DecodeJSONObject(data []byte) (m *Omap[string, json.RawMessage], err error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+
+	// Read object start
+	t, err := dec.Token()
+	if err != nil {
+		return
+	}
+	if t != json.Delim('{') {
+		err = ErrNotJSONObject
+		return
+	}
+
+	// Create new ordered map
+	m, err = New[string, json.RawMessage]()
+	if err != nil {
+		return
+	}
+
+	// Read object fields
+	for dec.More() {
+		t, err = dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := t.(string)
+
+		var value json.RawMessage
+		if err = dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		m.Set(key, value)
+	}
+
+	// Read object end
+	if _, err = dec.Token(); err != nil {
+		return nil, err
+	}
+
+	// Check there is no data after object end
+	if _, err = dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("%w: unexpected data after object end",
+			ErrNotJSONObject)
+	}
+	err = nil
+
+	return
+}
+
+// MarshalJSON encodes ordered map to JSON in order of default (insertion)
+// index. Map with keys of string or integer kind is encoded to JSON object,
+// map with keys of other kinds is encoded to JSON array of
+// {"key": ..., "value": ...} objects. Empty map is encoded to {} or [].
+func (m *Omap[K, D]) MarshalJSON() ([]byte, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	// Select JSON object or array delimiters
+	object := jsonObjectKeys[K]()
+	start, end := byte('['), byte(']')
+	if object {
+		start, end = '{', '}'
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(start)
+	for rec := m.Idx.first(); rec != nil; rec = m.Idx.next(rec) {
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+
+		// Encode array element
+		if !object {
+			data, err := json.Marshal(jsonPair[K, D]{rec.Key(), rec.Data()})
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(data)
+			continue
+		}
+
+		// Encode object field
+		key, err := json.Marshal(jsonKeyString(rec.Key()))
+		if err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(rec.Data())
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(data)
+	}
+	buf.WriteByte(end)
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON decodes JSON encoded by MarshalJSON to ordered map. It
+// removes all records of the map and adds decoded records in document order,
+// so all indexes are sorted. The JSON null does not change the map.
+//
+// Zero Omap value may be used as decode destination, it gets the default
+// index only. If decoded record violates unique index (see Index.Unique) it
+// returns ErrUniqueConstraint and leaves the map empty.
+func (m *Omap[K, D]) UnmarshalJSON(data []byte) (err error) {
+	if string(bytes.TrimSpace(data)) == "null" {
+		return
+	}
+
+	// Decode key-value pairs
+	var pairs []Pair[K, D]
+	if jsonObjectKeys[K]() {
+		var obj *Omap[string, json.RawMessage]
+		if obj, err = DecodeJSONObject(data); err != nil {
+			return
+		}
+		for key, raw := range obj.Records() {
+			var pair Pair[K, D]
+			if pair.Key, err = jsonParseKey[K](key); err != nil {
+				return
+			}
+			if err = json.Unmarshal(raw, &pair.Value); err != nil {
+				return
+			}
+			pairs = append(pairs, pair)
+		}
+	} else {
+		var jsonPairs []jsonPair[K, D]
+		if err = json.Unmarshal(data, &jsonPairs); err != nil {
+			return
+		}
+		for _, pair := range jsonPairs {
+			pairs = append(pairs, Pair[K, D](pair))
+		}
+	}
+
+	return m.load(pairs)
+}
+
+// jsonObjectKeys returns true if keys of type K are encoded as JSON object
+// field names: keys of string or integer kind.
+func jsonObjectKeys[K comparable]() bool {
+	switch reflect.TypeFor[K]().Kind() {
+	case reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return false
+}
+
+// jsonKeyString returns JSON object field name of key of string or integer
+// kind.
+func jsonKeyString[K comparable](key K) string {
+	v := reflect.ValueOf(key)
+	switch {
+	case v.Kind() == reflect.String:
+		return v.String()
+	case v.CanInt():
+		return strconv.FormatInt(v.Int(), 10)
+	default:
+		return strconv.FormatUint(v.Uint(), 10)
+	}
+}
+
+// jsonParseKey parses JSON object field name to key of string or integer
+// kind.
+func jsonParseKey[K comparable](s string) (key K, err error) {
+	v := reflect.ValueOf(&key).Elem()
+	switch {
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+	case v.CanInt():
+		var i int64
+		if i, err = strconv.ParseInt(s, 10, v.Type().Bits()); err == nil {
+			v.SetInt(i)
+		}
+	default:
+		var u uint64
+		if u, err = strconv.ParseUint(s, 10, v.Type().Bits()); err == nil {
+			v.SetUint(u)
+		}
+	}
+	return
+}
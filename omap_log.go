@@ -0,0 +1,55 @@
+// Copyright 2025 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Append-only ordered log definition.
+
+package omap
+
+// Log is an append-only ordered log based on ordered map. Records are added to
+// the log with Append method which assigns auto-incremented uint64 keys
+// (sequence numbers) starting from 1, so the default (insertion) index is
+// ordered by key.
+//
+// All ordered map methods are available, use Get to get record by sequence
+// number. Append never overwrites records: if record with the next sequence
+// number was added with Set methods, Append returns ErrKeyAllreadySet.
+type Log[D any] struct {
+	*Omap[uint64, D]
+
+	// Last appended key, protected by ordered map mutex
+	seq uint64
+}
+
+// NewLog creates a new ordered log object with data of type D.
+func NewLog[D any](sorts ...Index[uint64, D]) (l *Log[D], err error) {
+	m, err := New(sorts...)
+	if err != nil {
+		return
+	}
+
+	l = &Log[D]{Omap: m}
+	return
+}
+
+// Append adds new record to the back of the log and returns its key. It
+// returns ErrKeyAllreadySet if record with the next sequence number already
+// exists, the sequence number is consumed anyway, so next Append uses the
+// following one.
+func (l *Log[D]) Append(data D) (key uint64, err error) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.seq++
+	key = l.seq
+
+	// Check if key already exists
+	if _, ok := l.m[l.Idx.mapKey(key)]; ok {
+		err = ErrKeyAllreadySet
+		return
+	}
+
+	err = l.set(key, data, back)
+
+	return
+}
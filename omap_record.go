@@ -20,6 +20,18 @@ type Record[K comparable, D any] list.Element
 type recordValue[K comparable, D any] struct {
 	Key  K
 	Data D
+
+	// List elements of this record in each index list by index key
+	els map[any]*list.Element
+}
+
+// NewRecord creates new detached record with key and data. The record does
+// not belong to any ordered map, use it as a probe in search methods (like
+// SeekByIndex) or in tests and adapters.
+func NewRecord[K comparable, D any](key K, data D) *Record[K, D] {
+	return (*Record[K, D])(&list.Element{
+		Value: &recordValue[K, D]{Key: key, Data: data},
+	})
 }
 
 // Key returns record key.
@@ -45,6 +57,20 @@ func (r *Record[K, D]) Update(data D) {
 	}
 }
 
+// UpdateAndGet updates record data (value) and returns previous data.
+func (r *Record[K, D]) UpdateAndGet(data D) (old D) {
+	if v, ok := r.Value.(*recordValue[K, D]); ok {
+		old, v.Data = v.Data, data
+	}
+	return
+}
+
+// value returns record value or nil if record is empty.
+func (r *Record[K, D]) value() *recordValue[K, D] {
+	v, _ := r.Value.(*recordValue[K, D])
+	return v
+}
+
 // element returns list element from record.
 func (r *Record[K, D]) element() *list.Element {
 	return (*list.Element)(r)
@@ -0,0 +1,45 @@
+// Copyright 2025 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Registry of named index sets definition.
+
+package omap
+
+import (
+	"slices"
+	"sync"
+)
+
+// Registry of named index sets
+var indexSets = struct {
+	m map[string]any
+	sync.RWMutex
+}{m: make(map[string]any)}
+
+// RegisterIndexSet registers set of index definitions by name. Use NewNamed
+// to create ordered maps with this index set. Registering index set with
+// existing name replaces it.
+func RegisterIndexSet[K comparable, D any](name string, sorts ...Index[K, D]) {
+	indexSets.Lock()
+	defer indexSets.Unlock()
+
+	indexSets.m[name] = slices.Clone(sorts)
+}
+
+// NewNamed creates a new ordered map object with key of type K, data of type D
+// and index set registered by RegisterIndexSet with name. It returns
+// ErrIndexSetNotFound if index set with name is not registered for K and D
+// types.
+func NewNamed[K comparable, D any](name string) (m *Omap[K, D], err error) {
+	indexSets.RLock()
+	sorts, ok := indexSets.m[name].([]Index[K, D])
+	indexSets.RUnlock()
+
+	if !ok {
+		err = ErrIndexSetNotFound
+		return
+	}
+
+	return New(sorts...)
+}
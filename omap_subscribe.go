@@ -0,0 +1,102 @@
+// Copyright 2025 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Subscription to ordered map changes definition.
+
+package omap
+
+import (
+	"slices"
+	"sync"
+)
+
+// subscriber receives Set events to channel.
+type subscriber[K comparable, D any] struct {
+	ch    chan Pair[K, D]
+	block bool
+
+	// Closed by unsubscribe to release Set blocked on sending event
+	done chan struct{}
+}
+
+// Subscribe returns channel which receives key-value pairs of records added or
+// updated with Set methods after subscription, in order of changes, and
+// function which unsubscribes and closes the channel.
+//
+// The channel has buffer of buffer size. By default, when the buffer is full
+// the event is dropped, so slow consumers may lose events. Set block to true
+// to block the Set until the consumer receives event or unsubscribes instead.
+// The event is sent under the ordered map Lock, so blocking consumer must not
+// call omap methods which use mutex between receiving events avoid deadlocks.
+// The unsubscribe function may be called at any time, it releases blocked Set.
+func (m *Omap[K, D]) Subscribe(buffer int, block ...bool) (<-chan Pair[K, D],
+	func()) {
+
+	m.Lock()
+	defer m.Unlock()
+
+	// Create and add subscriber
+	sub := &subscriber[K, D]{
+		ch:    make(chan Pair[K, D], max(buffer, 0)),
+		block: len(block) > 0 && block[0],
+		done:  make(chan struct{}),
+	}
+	m.subs = append(m.subs, sub)
+
+	// Make unsubscribe function
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			// Release Set blocked on sending event before locking
+			close(sub.done)
+
+			m.Lock()
+			defer m.Unlock()
+
+			m.subs = slices.DeleteFunc(m.subs, func(s *subscriber[K, D]) bool {
+				return s == sub
+			})
+			close(sub.ch)
+		})
+	}
+
+	return sub.ch, unsubscribe
+}
+
+// change is a change of ordered map buffered by transaction Commit.
+type change[K comparable, D any] struct {
+	op   string
+	key  K
+	data D
+}
+
+// changed writes change to journal and sends Set events to subscribers, or
+// buffers change if pending is set. Unsafe (does not lock).
+func (in *Indexes[K, D]) changed(op string, key K, data D) {
+	if in.pending != nil {
+		*in.pending = append(*in.pending, change[K, D]{op, key, data})
+		return
+	}
+
+	in.jrn.write(op, key, data)
+
+	// Send Set events to subscribers
+	if op != JournalSet {
+		return
+	}
+	for _, sub := range in.subs {
+		pair := Pair[K, D]{Key: key, Value: data}
+		if sub.block {
+			select {
+			case sub.ch <- pair:
+			case <-sub.done:
+			}
+			continue
+		}
+		select {
+		case sub.ch <- pair:
+		default:
+		}
+	}
+}
@@ -1,8 +1,22 @@
 package omap
 
 import (
+	"bytes"
+	"cmp"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"os"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestOmap(t *testing.T) {
@@ -307,6 +321,2846 @@ func TestRecords(t *testing.T) {
 	}
 }
 
+func TestTrim(t *testing.T) {
+	t.Log("TestTrim")
+
+	// Create new ordered map with index by key
+	m, err := New(Index[int, int]{Key: "key", Func: CompareByKey[int, int]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 10; i++ {
+		m.Set(i, i*10)
+	}
+
+	// Keep first 6 records
+	m.TrimFront(6)
+	if m.Len() != 6 {
+		t.Fatal("wrong length after TrimFront:", m.Len())
+	}
+	if rec := m.Idx.Last("key"); rec == nil || rec.Key() != 6 {
+		t.Fatal("wrong last record in key index after TrimFront")
+	}
+
+	// Keep last 3 records
+	m.TrimBack(3)
+	if m.Len() != 3 {
+		t.Fatal("wrong length after TrimBack:", m.Len())
+	}
+	if rec := m.Idx.First("key"); rec == nil || rec.Key() != 4 {
+		t.Fatal("wrong first record in key index after TrimBack")
+	}
+	for _, pair := range m.Pairs("key") {
+		t.Log(pair.Key, pair.Value)
+	}
+
+	// Deleted records should be removed from all indexes
+	m.Del(5)
+	if pairs := m.Pairs("key"); len(pairs) != 2 || pairs[1].Key != 6 {
+		t.Fatal("wrong key index after Del:", pairs)
+	}
+}
+
+func TestCompareByKeyThen(t *testing.T) {
+	t.Log("TestCompareByKeyThen")
+
+	// Create new ordered map with indexes by value and by age with key
+	// tie-break
+	o, err := New(
+		Index[string, int]{Key: "Value", Func: CompareByValueThenKey[string, int]},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := New(
+		Index[string, *Person]{Key: "Age",
+			Func: CompareByKeyThen(CompareByAgeAsc)},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Add records with equal values
+	for _, key := range []string{"d", "b", "c", "a"} {
+		o.Set(key, 1)
+		p.Set(key, &Person{Name: key, Age: 30})
+	}
+	o.Set("e", 0)
+
+	// Check order
+	if keys := pairKeys(o.Pairs("Value")); keys != "e,a,b,c,d" {
+		t.Fatal("wrong order by value index:", keys)
+	}
+	if keys := pairKeys(p.Pairs("Age")); keys != "a,b,c,d" {
+		t.Fatal("wrong order by age index:", keys)
+	}
+}
+
+// pairKeys returns comma separated keys of pairs.
+func pairKeys[D any](pairs []Pair[string, D]) string {
+	var keys []string
+	for _, pair := range pairs {
+		keys = append(keys, pair.Key)
+	}
+	return strings.Join(keys, ",")
+}
+
+func TestSetNoReindex(t *testing.T) {
+	t.Log("TestSetNoReindex")
+
+	o, err := New(Index[string, *Person]{Key: "AgeAsc", Func: CompareByAgeAsc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set("John", &Person{Name: "John", Age: 30})
+	o.Set("Jane", &Person{Name: "Jane", Age: 25})
+
+	// Update without reindex keeps index order
+	o.SetNoReindex("Jane", &Person{Name: "Jane", Age: 50})
+	if keys := pairKeys(o.Pairs("AgeAsc")); keys != "Jane,John" {
+		t.Fatal("wrong order after SetNoReindex:", keys)
+	}
+
+	// Refresh sorts index
+	o.Refresh()
+	if keys := pairKeys(o.Pairs("AgeAsc")); keys != "John,Jane" {
+		t.Fatal("wrong order after Refresh:", keys)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	t.Log("TestDrain")
+
+	o, err := New(Index[string, *Person]{Key: "AgeAsc", Func: CompareByAgeAsc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set("John", &Person{Name: "John", Age: 30})
+	o.Set("Jane", &Person{Name: "Jane", Age: 25})
+	o.Set("Bob", &Person{Name: "Bob", Age: 40})
+
+	// Drain first record by age and stop
+	for key, val := range o.Drain("AgeAsc") {
+		t.Log(key, val)
+		break
+	}
+	if o.Len() != 2 || o.Exists("Jane") {
+		t.Fatal("wrong map after partial drain")
+	}
+
+	// Drain the rest records
+	for key, val := range o.Drain() {
+		t.Log(key, val)
+	}
+	if o.Len() != 0 || o.Idx.First("AgeAsc") != nil {
+		t.Fatal("map is not empty after drain")
+	}
+}
+
+func TestForEachOf(t *testing.T) {
+	t.Log("TestForEachOf")
+
+	o, err := New[string, int]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set("one", 1)
+	o.Set("two", 2)
+	o.Set("three", 3)
+
+	var found []int
+	o.ForEachOf([]string{"three", "four", "one"}, func(key string, data int, ok bool) {
+		t.Log(key, data, ok)
+		if ok {
+			found = append(found, data)
+		}
+	})
+	if len(found) != 2 || found[0] != 3 || found[1] != 1 {
+		t.Fatal("wrong data found:", found)
+	}
+}
+
+func TestRebuildIndex(t *testing.T) {
+	t.Log("TestRebuildIndex")
+
+	o, err := New(Index[string, *Person]{Key: "AgeAsc", Func: CompareByAgeAsc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set("John", &Person{Name: "John", Age: 30})
+	o.Set("Jane", &Person{Name: "Jane", Age: 25})
+	o.Set("Bob", &Person{Name: "Bob", Age: 40})
+
+	// Corrupt index list
+	o.lm["AgeAsc"].Remove(o.lm["AgeAsc"].Front())
+
+	if err = o.RebuildIndex("AgeAsc"); err != nil {
+		t.Fatal(err)
+	}
+	if keys := pairKeys(o.Pairs("AgeAsc")); keys != "Jane,John,Bob" {
+		t.Fatal("wrong order after RebuildIndex:", keys)
+	}
+
+	// Check incorrect index keys
+	if err = o.RebuildIndex(0); err != ErrIncorrectIndexKey {
+		t.Fatal("wrong error for default index:", err)
+	}
+	if err = o.RebuildIndex("Name"); err != ErrIncorrectIndexKey {
+		t.Fatal("wrong error for unknown index:", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Log("TestValidate")
+
+	o, err := New(
+		Index[string, *Person]{Key: "Name", Func: CompareByName},
+		Index[string, *Person]{Key: "AgeAsc", Func: CompareByAgeAsc},
+		Index[string, *Person]{Key: "AgeDesc", Func: CompareByAgeDesc},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{"John", "Jane", "Bob", "Alice", "Tom", "Ann"} {
+		o.Set(name, &Person{Name: name, Age: 20 + (i*7)%11})
+	}
+	o.Set("Jane", &Person{Name: "Jane", Age: 99})
+	o.Del("Bob")
+	if err = o.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Change data directly without Refresh
+	rec, _ := o.GetRecord("Tom")
+	rec.Data().Age = 0
+	if err = o.Validate(); err == nil {
+		t.Fatal("unsorted index was not detected")
+	}
+	t.Log(err)
+	o.Refresh()
+	if err = o.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Corrupt index list
+	o.lm["Name"].Remove(o.lm["Name"].Back())
+	if err = o.Validate(); err == nil {
+		t.Fatal("corrupted index was not detected")
+	}
+	t.Log(err)
+}
+
+func TestSortInconsistent(t *testing.T) {
+	t.Log("TestSortInconsistent")
+
+	// Create new ordered map with inconsistent sort function which reports
+	// any record as greater than next
+	o, err := New(Index[int, int]{Key: "bad", Func: func(r1, r2 *Record[int, int]) int {
+		return 1
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sort must finish
+	for i := range 50 {
+		o.Set(i, i)
+	}
+	o.Refresh()
+	if len(o.Pairs("bad")) != 50 {
+		t.Fatal("wrong bad index length")
+	}
+
+	// Moves limit guard must fire
+	if o.SortLimitExceeded() == 0 {
+		t.Fatal("sort moves limit was not exceeded")
+	}
+}
+
+func TestGroupByValue(t *testing.T) {
+	t.Log("TestGroupByValue")
+
+	o, err := New[string, int]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set("a", 1)
+	o.Set("b", 2)
+	o.Set("c", 1)
+	o.Set("d", 3)
+	o.Set("e", 2)
+
+	groups := o.GroupByValue(func(a, b int) bool { return a == b })
+	t.Log(groups)
+	if len(groups) != 3 || strings.Join(groups[0], ",") != "a,c" ||
+		strings.Join(groups[1], ",") != "b,e" || groups[2][0] != "d" {
+		t.Fatal("wrong groups:", groups)
+	}
+}
+
+func TestRecordPool(t *testing.T) {
+	t.Log("TestRecordPool")
+
+	o, err := New(
+		Index[int, int]{Key: "key", Func: CompareByKey[int, int]},
+		WithRecordPool[int, int](true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 100 {
+		o.Set(i, i)
+		if i%3 == 0 {
+			o.Del(i / 2)
+		}
+	}
+	if err = o.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := o.Get(99); !ok || data != 99 {
+		t.Fatal("wrong data:", data, ok)
+	}
+
+	// Record returned by DelLast keeps its key and data
+	rec, data, ok := o.DelLast()
+	if !ok || rec.Key() != 99 || rec.Data() != 99 || data != 99 {
+		t.Fatal("wrong deleted record:", rec.Key(), rec.Data(), data, ok)
+	}
+	o.Set(100, 100)
+	if rec.Key() != 99 || rec.Data() != 99 {
+		t.Fatal("deleted record reused:", rec.Key(), rec.Data())
+	}
+}
+
+func TestSeekByIndex(t *testing.T) {
+	t.Log("TestSeekByIndex")
+
+	o, err := New(Index[int, string]{Key: "key", Func: CompareByKey[int, string]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []int{50, 10, 40, 20} {
+		o.Set(key, "")
+	}
+
+	for _, test := range []struct{ probe, key int }{
+		{20, 20}, {25, 40}, {5, 10}, {60, 0},
+	} {
+		rec, exact := o.SeekByIndex("key", NewRecord(test.probe, ""))
+		switch {
+		case test.key == 0 && rec != nil:
+			t.Fatal("record found for probe", test.probe)
+		case test.key != 0 && (rec == nil || rec.Key() != test.key ||
+			exact != (test.key == test.probe)):
+			t.Fatal("wrong record for probe", test.probe)
+		}
+	}
+}
+
+func TestLog(t *testing.T) {
+	t.Log("TestLog")
+
+	l, err := NewLog[string]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range []string{"start", "run", "stop"} {
+		key, err := l.Append(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Log(key, event)
+	}
+
+	if data, ok := l.Get(2); !ok || data != "run" {
+		t.Fatal("wrong record 2:", data)
+	}
+	if rec := l.Idx.Last(); rec == nil || rec.Key() != 3 {
+		t.Fatal("wrong last record")
+	}
+
+	// Append does not overwrite record added with Set
+	l.Set(5, "manual")
+	if _, err = l.Append("x"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = l.Append("y"); err != ErrKeyAllreadySet {
+		t.Fatal("wrong error:", err)
+	}
+	if key, err := l.Append("z"); err != nil || key != 6 {
+		t.Fatal("wrong key after error:", key, err)
+	}
+	if data, _ := l.Get(5); data != "manual" {
+		t.Fatal("record overwritten:", data)
+	}
+}
+
+func TestJournal(t *testing.T) {
+	t.Log("TestJournal")
+
+	o, err := New[string, int]()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Start journal
+	var journal strings.Builder
+	o.Journal(&journal, func(op string, key string, data int) []byte {
+		return fmt.Appendf(nil, "%s %s %d\n", op, key, data)
+	})
+
+	o.Set("one", 1)
+	o.Set("two", 2)
+	o.Set("one", 11)
+	o.Del("two")
+	o.Clear()
+
+	// Stop journal
+	o.Journal(nil, nil)
+	o.Set("three", 3)
+
+	t.Log("\n" + journal.String())
+	expected := "set one 1\nset two 2\nset one 11\ndel two 2\nclear  0\n"
+	if journal.String() != expected {
+		t.Fatal("wrong journal")
+	}
+}
+
+func TestIterate(t *testing.T) {
+	t.Log("TestIterate")
+
+	o, err := New(Index[string, *Person]{Key: "AgeAsc", Func: CompareByAgeAsc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set("John", &Person{Name: "John", Age: 30})
+	o.Set("Jane", &Person{Name: "Jane", Age: 25})
+	o.Set("Bob", &Person{Name: "Bob", Age: 40})
+
+	for _, test := range []struct {
+		dir    Direction
+		idxKey []any
+		keys   string
+	}{
+		{Forward, nil, "John,Jane,Bob"},
+		{Backward, nil, "Bob,Jane,John"},
+		{Forward, []any{"AgeAsc"}, "Jane,John,Bob"},
+		{Backward, []any{"AgeAsc"}, "Bob,John,Jane"},
+	} {
+		var keys []string
+		o.Iterate(test.dir, func(key string, data *Person) {
+			keys = append(keys, key)
+		}, test.idxKey...)
+		if strings.Join(keys, ",") != test.keys {
+			t.Fatal("wrong order:", keys, "expected:", test.keys)
+		}
+	}
+}
+
+func TestInsertMany(t *testing.T) {
+	t.Log("TestInsertMany")
+
+	o, err := New[string, int]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set("c", 3)
+	pairs := []Pair[string, int]{{"a", 1}, {"b", 2}, {"c", 30}, {"d", 4}}
+
+	// Insert with rollback
+	inserted, err := o.InsertMany(pairs, true)
+	if err != ErrKeyAllreadySet || inserted != 0 || o.Len() != 1 {
+		t.Fatal("wrong insert with rollback:", inserted, err, o.Len())
+	}
+
+	// Insert without rollback
+	inserted, err = o.InsertMany(pairs)
+	if err != ErrKeyAllreadySet || inserted != 2 || o.Len() != 3 {
+		t.Fatal("wrong insert:", inserted, err, o.Len())
+	}
+	if data, _ := o.Get("c"); data != 3 {
+		t.Fatal("existing record was updated")
+	}
+}
+
+func TestSetDefaultComparator(t *testing.T) {
+	t.Log("TestSetDefaultComparator")
+
+	o, err := New(Index[string, *Person]{Key: "Name", Func: CompareByName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set("John", &Person{Name: "John", Age: 30})
+	o.Set("Jane", &Person{Name: "Jane", Age: 25})
+
+	// Sort default index by age
+	o.SetDefaultComparator(CompareByAgeAsc)
+	o.Set("Bob", &Person{Name: "Bob", Age: 40})
+	o.SetFirst("Alice", &Person{Name: "Alice", Age: 35})
+	if keys := pairKeys(o.Pairs()); keys != "Jane,John,Alice,Bob" {
+		t.Fatal("wrong default order:", keys)
+	}
+	if err = o.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Moves are not allowed
+	if err = o.Idx.MoveToFront(o.Idx.Last()); err != ErrDefaultIndexSorted {
+		t.Fatal("wrong move error:", err)
+	}
+
+	// Inserts at position are not allowed
+	first := o.Idx.First()
+	if err = o.Idx.InsertAfter("Tom", &Person{Name: "Tom", Age: 50},
+		first); err != ErrDefaultIndexSorted {
+		t.Fatal("wrong insert after error:", err)
+	}
+	if err = o.Idx.InsertBefore("Tom", &Person{Name: "Tom", Age: 50},
+		first); err != ErrDefaultIndexSorted {
+		t.Fatal("wrong insert before error:", err)
+	}
+	if o.Exists("Tom") {
+		t.Fatal("record inserted to sorted default index")
+	}
+
+	// Return to insertion order
+	o.SetDefaultComparator(nil)
+	o.Set("Ann", &Person{Name: "Ann", Age: 20})
+	if keys := pairKeys(o.Pairs()); keys != "Jane,John,Alice,Bob,Ann" {
+		t.Fatal("wrong default order:", keys)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	t.Log("TestSubscribe")
+
+	o, err := New[string, int]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set("zero", 0)
+
+	// Subscribe with buffer of 2 events
+	ch, unsubscribe := o.Subscribe(2)
+	o.Set("one", 1)
+	o.Set("two", 2)
+	o.Set("three", 3) // dropped
+	unsubscribe()
+	o.Set("four", 4)
+
+	var keys []string
+	for pair := range ch {
+		keys = append(keys, pair.Key)
+	}
+	if strings.Join(keys, ",") != "one,two" {
+		t.Fatal("wrong events:", keys)
+	}
+}
+
+func TestNilSafe(t *testing.T) {
+	t.Log("TestNilSafe")
+
+	o, err := New(
+		Index[string, *Person]{Key: "Name", Func: NilSafe(CompareByName)},
+		Index[string, *Person]{Key: "AgeAsc", Func: NilSafeFirst(CompareByAgeAsc)},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set("John", &Person{Name: "John", Age: 30})
+	o.Set("Nobody", nil)
+	o.Set("Jane", &Person{Name: "Jane", Age: 25})
+
+	if keys := pairKeys(o.Pairs("Name")); keys != "Jane,John,Nobody" {
+		t.Fatal("wrong order by name:", keys)
+	}
+	if keys := pairKeys(o.Pairs("AgeAsc")); keys != "Nobody,Jane,John" {
+		t.Fatal("wrong order by age:", keys)
+	}
+}
+
+func TestLoadOrStoreRecord(t *testing.T) {
+	t.Log("TestLoadOrStoreRecord")
+
+	o, err := New[string, int]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set("one", 1)
+
+	rec, loaded := o.LoadOrStoreRecord("two", 2)
+	if loaded || rec.Data() != 2 {
+		t.Fatal("wrong stored record")
+	}
+	rec, loaded = o.LoadOrStoreRecord("one", 10)
+	if !loaded || rec.Data() != 1 {
+		t.Fatal("wrong loaded record")
+	}
+
+	// Move loaded record to the back
+	o.Idx.MoveToBack(rec)
+	if keys := pairKeys(o.Pairs()); keys != "two,one" {
+		t.Fatal("wrong order:", keys)
+	}
+}
+
+func TestForeignRecord(t *testing.T) {
+	t.Log("TestForeignRecord")
+
+	o, err := New(Index[string, int]{Key: "key", Func: CompareByKey[string, int]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := New[string, int]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"c", "b", "a"} {
+		o.Set(key, 0)
+		other.Set(key, 0)
+	}
+
+	// Move record of other map
+	if err = o.Idx.MoveToFront(other.Idx.Last()); err != ErrForeignRecord {
+		t.Fatal("wrong error for foreign record:", err)
+	}
+	if err = o.Idx.InsertBefore("d", 0, other.Idx.First()); err != ErrForeignRecord {
+		t.Fatal("wrong error for foreign mark:", err)
+	}
+
+	// Move record got from key index
+	if err = o.Idx.MoveToFront(o.Idx.First("key")); err != nil {
+		t.Fatal(err)
+	}
+	if keys := pairKeys(o.Pairs()); keys != "a,c,b" {
+		t.Fatal("wrong order:", keys)
+	}
+
+	// Move removed record
+	rec := o.Idx.Last()
+	o.Del(rec.Key())
+	if err = o.Idx.MoveToFront(rec); err != ErrForeignRecord {
+		t.Fatal("wrong error for removed record:", err)
+	}
+}
+
+func TestPositions(t *testing.T) {
+	t.Log("TestPositions")
+
+	o, err := New(
+		Index[string, *Person]{Key: "Name", Func: CompareByName},
+		Index[string, *Person]{Key: "AgeDesc", Func: CompareByAgeDesc},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set("John", &Person{Name: "John", Age: 30})
+	o.Set("Jane", &Person{Name: "Jane", Age: 25})
+	o.Set("Bob", &Person{Name: "Bob", Age: 40})
+
+	positions, ok := o.Positions("Bob")
+	t.Log(positions, ok)
+	if !ok || positions[0] != 2 || positions["Name"] != 0 ||
+		positions["AgeDesc"] != 0 {
+		t.Fatal("wrong positions:", positions)
+	}
+	if _, ok = o.Positions("Alice"); ok {
+		t.Fatal("positions of not existing key")
+	}
+}
+
+func TestNewNamed(t *testing.T) {
+	t.Log("TestNewNamed")
+
+	RegisterIndexSet("persons",
+		Index[string, *Person]{Key: "Name", Func: CompareByName},
+		Index[string, *Person]{Key: "AgeAsc", Func: CompareByAgeAsc},
+	)
+
+	o, err := NewNamed[string, *Person]("persons")
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set("John", &Person{Name: "John", Age: 30})
+	o.Set("Jane", &Person{Name: "Jane", Age: 25})
+	if keys := pairKeys(o.Pairs("AgeAsc")); keys != "Jane,John" {
+		t.Fatal("wrong order:", keys)
+	}
+
+	// Index set with other types or name
+	if _, err = NewNamed[string, Person]("persons"); err != ErrIndexSetNotFound {
+		t.Fatal("wrong error for other types:", err)
+	}
+	if _, err = NewNamed[string, *Person]("users"); err != ErrIndexSetNotFound {
+		t.Fatal("wrong error for other name:", err)
+	}
+}
+
+func TestMoveBy(t *testing.T) {
+	t.Log("TestMoveBy")
+
+	o, err := New[string, int]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		o.Set(key, 0)
+	}
+
+	for _, test := range []struct {
+		key    string
+		offset int
+		keys   string
+	}{
+		{"d", -2, "a,d,b,c,e"},
+		{"a", 3, "d,b,c,a,e"},
+		{"c", -10, "c,d,b,a,e"},
+		{"d", 10, "c,b,a,e,d"},
+		{"b", 0, "c,b,a,e,d"},
+	} {
+		rec, _ := o.GetRecord(test.key)
+		if err = o.Idx.MoveBy(rec, test.offset); err != nil {
+			t.Fatal(err)
+		}
+		if keys := pairKeys(o.Pairs()); keys != test.keys {
+			t.Fatal("wrong order:", keys, "expected:", test.keys)
+		}
+	}
+}
+
+func TestAppendPairs(t *testing.T) {
+	t.Log("TestAppendPairs")
+
+	o, err := New[string, int]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set("one", 1)
+	o.Set("two", 2)
+
+	buf := o.AppendPairs(nil)
+	buf = o.AppendPairs(buf)
+	if keys := pairKeys(buf); keys != "one,two,one,two" {
+		t.Fatal("wrong pairs:", keys)
+	}
+
+	// Reuse buffer
+	p := &buf[0]
+	buf = o.AppendPairs(buf[:0])
+	if keys := pairKeys(buf); keys != "one,two" || p != &buf[0] {
+		t.Fatal("buffer was not reused:", keys)
+	}
+}
+
+func TestNewFold(t *testing.T) {
+	t.Log("TestNewFold")
+
+	o, err := NewFold(Index[string, int]{Key: "key", Func: CompareByKey[string, int]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set("Content-Type", 1)
+	o.Set("Accept", 2)
+
+	if data, ok := o.Get("content-type"); !ok || data != 1 {
+		t.Fatal("wrong data by folded key:", data, ok)
+	}
+	if !o.Exists("ACCEPT") {
+		t.Fatal("key not found by upper case")
+	}
+	if keys := pairKeys(o.Pairs()); keys != "Content-Type,Accept" {
+		t.Fatal("wrong keys:", keys)
+	}
+
+	// Last write wins
+	o.Set("CONTENT-TYPE", 3)
+	if keys := pairKeys(o.Pairs("key")); keys != "Accept,CONTENT-TYPE" || o.Len() != 2 {
+		t.Fatal("wrong keys after update:", keys)
+	}
+
+	if _, ok := o.Del("content-TYPE"); !ok {
+		t.Fatal("key not deleted")
+	}
+	if err = o.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReap(t *testing.T) {
+	t.Log("TestReap")
+
+	o, err := New[int, int]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 10 {
+		o.Set(i, i)
+	}
+
+	// Reap odd records
+	var reaped []int
+	o.Reap(func(key, data int) bool { return data%2 == 1 },
+		func(key, data int) { reaped = append(reaped, key) })
+	if len(reaped) != 5 || reaped[0] != 1 || reaped[4] != 9 || o.Len() != 5 {
+		t.Fatal("wrong reaped records:", reaped, o.Len())
+	}
+}
+
+func TestNewRecord(t *testing.T) {
+	t.Log("TestNewRecord")
+
+	o, err := New[string, int]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set("one", 1)
+
+	rec := NewRecord("one", 1)
+	if rec.Key() != "one" || rec.Data() != 1 {
+		t.Fatal("wrong record:", rec.Key(), rec.Data())
+	}
+	if err = o.Idx.MoveToBack(rec); err != ErrForeignRecord {
+		t.Fatal("wrong error for detached record:", err)
+	}
+}
+
+func TestDecodeJSONObject(t *testing.T) {
+	t.Log("TestDecodeJSONObject")
+
+	m, err := DecodeJSONObject([]byte(`{"z": 1, "a": {"b": [1, 2]}, "m": "text"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := pairKeys(m.Pairs()); keys != "z,a,m" {
+		t.Fatal("wrong keys order:", keys)
+	}
+	if data, _ := m.Get("a"); string(data) != `{"b": [1, 2]}` {
+		t.Fatal("wrong raw value:", string(data))
+	}
+
+	// Decode not an object
+	if _, err = DecodeJSONObject([]byte(`[1, 2]`)); err != ErrNotJSONObject {
+		t.Fatal("wrong error for array:", err)
+	}
+	if _, err = DecodeJSONObject([]byte(`{"a": 1`)); err == nil {
+		t.Fatal("no error for broken json")
+	}
+
+	// Decode object with trailing data
+	for _, data := range []string{`{"a":1} garbage`, `{"a":1}{"b":2}`} {
+		if _, err = DecodeJSONObject([]byte(data)); err == nil {
+			t.Fatal("no error for trailing data:", data)
+		}
+	}
+	if _, err = DecodeJSONObject([]byte("{\"a\":1}\n")); err != nil {
+		t.Fatal("error for trailing white space:", err)
+	}
+}
+
+func TestCompactFunc(t *testing.T) {
+	t.Log("TestCompactFunc")
+
+	o, err := New[int, string]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, data := range []string{"a", "a", "b", "b", "b", "a", "c", "c"} {
+		o.Set(i, data)
+	}
+
+	removed := o.CompactFunc(func(a, b string) bool { return a == b })
+	var values []string
+	o.ForEach(func(key int, data string) { values = append(values, data) })
+	if removed != 4 || strings.Join(values, "") != "abac" {
+		t.Fatal("wrong compact:", removed, values)
+	}
+}
+
+func TestSuspendIndexes(t *testing.T) {
+	t.Log("TestSuspendIndexes")
+
+	// Load million records only if OMAP_TEST_LARGE environment variable is
+	// set, it takes long time
+	n := 10_000
+	if os.Getenv("OMAP_TEST_LARGE") != "" {
+		n = 1_000_000
+	}
+
+	o, err := New(
+		Index[int, int]{Key: "key", Func: CompareByKey[int, int]},
+		Index[int, int]{Key: "value", Func: CompareByValueThenKey[int, int]},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Load records with suspended indexes
+	o.SuspendIndexes()
+	for i := range n {
+		o.Set(n-i, i%1000)
+	}
+	o.ResumeIndexes()
+
+	if err = o.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if rec := o.Idx.First("key"); rec == nil || rec.Key() != 1 {
+		t.Fatal("wrong first record by key")
+	}
+	if rec := o.Idx.Last("value"); rec == nil || rec.Data() != 999 {
+		t.Fatal("wrong last record by value")
+	}
+}
+
+func TestEnumerate(t *testing.T) {
+	t.Log("TestEnumerate")
+
+	o, err := New(Index[string, *Person]{Key: "AgeAsc", Func: CompareByAgeAsc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set("John", &Person{Name: "John", Age: 30})
+	o.Set("Jane", &Person{Name: "Jane", Age: 25})
+	o.Set("Bob", &Person{Name: "Bob", Age: 40})
+
+	var keys []string
+	for i, pair := range o.Enumerate("AgeAsc") {
+		t.Log(i, pair.Key, pair.Value)
+		if i != len(keys) {
+			t.Fatal("wrong position:", i)
+		}
+		keys = append(keys, pair.Key)
+	}
+	if strings.Join(keys, ",") != "Jane,John,Bob" {
+		t.Fatal("wrong order:", keys)
+	}
+}
+
+func TestUpdateFunc(t *testing.T) {
+	t.Log("TestUpdateFunc")
+
+	o, err := New(Index[string, Person]{Key: "AgeAsc", Func: func(r1, r2 *Record[string, Person]) int {
+		return r1.Data().Age - r2.Data().Age
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set("John", Person{Name: "John", Age: 30})
+	o.Set("Jane", Person{Name: "Jane", Age: 25})
+	o.Set("Bob", Person{Name: "Bob", Age: 40})
+
+	// Make persons younger than 35 older by 20 years
+	updated := o.UpdateFunc(func(key string, p Person) bool { return p.Age < 35 },
+		func(p Person) Person { p.Age += 20; return p })
+	if updated != 2 {
+		t.Fatal("wrong number of updated records:", updated)
+	}
+	if keys := pairKeys(o.Pairs("AgeAsc")); keys != "Bob,Jane,John" {
+		t.Fatal("wrong order:", keys)
+	}
+}
+
+func TestNewIncorrectIndexKey(t *testing.T) {
+	t.Log("TestNewIncorrectIndexKey")
+
+	for _, key := range []any{0, []string{"Name"}, [1]any{map[string]int{}}} {
+		_, err := New(Index[string, *Person]{Key: key, Func: CompareByName})
+		if !errors.Is(err, ErrIncorrectIndexKey) {
+			t.Fatal("wrong error for index key", key, err)
+		}
+		t.Log(err)
+	}
+}
+
+func TestMergeSeq(t *testing.T) {
+	t.Log("TestMergeSeq")
+
+	var maps []*Omap[int, string]
+	for _, keys := range [][]int{{1, 4, 7}, {2, 3, 9}, {}, {5, 6, 8, 10}} {
+		m, err := New[int, string]()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range keys {
+			m.Set(key, fmt.Sprint(key))
+		}
+		maps = append(maps, m)
+	}
+
+	less := func(a, b Pair[int, string]) bool { return a.Key < b.Key }
+	var keys []int
+	for key := range MergeSeq(less, maps...) {
+		keys = append(keys, key)
+		if key == 9 {
+			break
+		}
+	}
+	if fmt.Sprint(keys) != "[1 2 3 4 5 6 7 8 9]" {
+		t.Fatal("wrong merge:", keys)
+	}
+
+	// Maps are unlocked after iteration
+	maps[0].Set(11, "11")
+}
+
+func TestWithTieBreak(t *testing.T) {
+	t.Log("TestWithTieBreak")
+
+	o, err := New(
+		WithTieBreak(CompareByKey[string, *Person]),
+		Index[string, *Person]{Key: "AgeAsc", Func: CompareByAgeAsc},
+		Index[string, *Person]{Key: "AgeDesc", Func: CompareByAgeDesc},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"John", "Bob", "Jane", "Alice"} {
+		o.Set(name, &Person{Name: name, Age: 30})
+	}
+	o.Set("Tom", &Person{Name: "Tom", Age: 20})
+
+	if keys := pairKeys(o.Pairs("AgeAsc")); keys != "Tom,Alice,Bob,Jane,John" {
+		t.Fatal("wrong order by age ascending:", keys)
+	}
+	if keys := pairKeys(o.Pairs("AgeDesc")); keys != "Alice,Bob,Jane,John,Tom" {
+		t.Fatal("wrong order by age descending:", keys)
+	}
+}
+
+func TestMoveMatchingToFront(t *testing.T) {
+	t.Log("TestMoveMatchingToFront")
+
+	o, err := New[string, int]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range []string{"a", "b", "c", "d", "e"} {
+		o.Set(key, i)
+	}
+
+	moved := o.Idx.MoveMatchingToFront(func(key string, data int) bool {
+		return data%2 == 1
+	})
+	if keys := pairKeys(o.Pairs()); moved != 2 || keys != "b,d,a,c,e" {
+		t.Fatal("wrong order:", keys, moved)
+	}
+}
+
+func TestSubscribeBlockUnsubscribe(t *testing.T) {
+	t.Log("TestSubscribeBlockUnsubscribe")
+
+	o, err := New[string, int]()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Blocking subscriber which never reads events
+	_, unsubscribe := o.Subscribe(0, true)
+	set := make(chan struct{})
+	go func() {
+		o.Set("one", 1)
+		close(set)
+	}()
+
+	// Unsubscribe must release blocked Set and return
+	unsubscribed := make(chan struct{})
+	go func() {
+		unsubscribe()
+		close(unsubscribed)
+	}()
+	for _, ch := range []chan struct{}{set, unsubscribed} {
+		select {
+		case <-ch:
+		case <-time.After(5 * time.Second):
+			t.Fatal("deadlock on unsubscribe")
+		}
+	}
+	if data, ok := o.Get("one"); !ok || data != 1 {
+		t.Fatal("wrong data:", data, ok)
+	}
+}
+
+func TestIndexFuncCtx(t *testing.T) {
+	t.Log("TestIndexFuncCtx")
+
+	// Sort records by distance of their data from data of the "pivot" record
+	o, err := New(Index[string, int]{Key: "near", FuncCtx: func(r1,
+		r2 *Record[string, int], ctx *Omap[string, int]) int {
+
+		pivot, _ := ctx.Get("pivot", true)
+		d1, d2 := abs(r1.Data()-pivot), abs(r2.Data()-pivot)
+		return d1 - d2
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set("pivot", 10)
+	for key, data := range map[string]int{"a": 1, "b": 12, "c": 30, "d": 7} {
+		o.Set(key, data)
+	}
+	if keys := pairKeys(o.Pairs("near")); keys != "pivot,b,d,a,c" {
+		t.Fatal("wrong order:", keys)
+	}
+
+	// Change pivot and sort again
+	o.Set("pivot", 28)
+	if keys := pairKeys(o.Pairs("near")); keys != "pivot,c,b,d,a" {
+		t.Fatal("wrong order after pivot change:", keys)
+	}
+}
+
+func TestIndexFuncCtxShare(t *testing.T) {
+	t.Log("TestIndexFuncCtxShare")
+
+	// Sort records by distance of their share of total from the average share
+	o, err := New(Index[string, int]{Key: "avg", FuncCtx: func(r1,
+		r2 *Record[string, int], ctx *Omap[string, int]) int {
+
+		var total int
+		for _, data := range ctx.RecordsUnsafe() {
+			total += data
+		}
+		avg := float64(total) / float64(ctx.LenUnsafe())
+		d1 := math.Abs(float64(r1.Data()) - avg)
+		d2 := math.Abs(float64(r2.Data()) - avg)
+		return cmp.Compare(d1, d2)
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, data := range map[string]int{"a": 10, "b": 40, "c": 25, "d": 5} {
+		o.Set(key, data)
+	}
+	o.Refresh()
+	if keys := pairKeys(o.Pairs("avg")); keys != "c,a,d,b" {
+		t.Fatal("wrong order:", keys)
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func TestSetBounded(t *testing.T) {
+	t.Log("TestSetBounded")
+
+	o, err := New[string, int]()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Fill map up to the boundary
+	for i, key := range []string{"a", "b", "c"} {
+		if err = o.SetBounded(key, i, 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// New key is rejected when map is full
+	if err = o.SetBounded("d", 3, 3); err != ErrMapFull {
+		t.Fatal("wrong error:", err)
+	}
+
+	// Existing key is updated when map is full
+	if err = o.SetBounded("b", 10, 3); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := o.Get("b"); data != 10 || o.Len() != 3 {
+		t.Fatal("wrong update:", data, o.Len())
+	}
+}
+
+func TestProject(t *testing.T) {
+	t.Log("TestProject")
+
+	o, err := New(Index[string, *Person]{Key: "age", Func: CompareByAgeAsc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set("john", &Person{Name: "John", Age: 30})
+	o.Set("jane", &Person{Name: "Jane", Age: 20})
+
+	names := Project(o, func(key string, p *Person) string {
+		return fmt.Sprint(p.Name, ":", p.Age)
+	}, "age")
+	if strings.Join(names, ",") != "Jane:20,John:30" {
+		t.Fatal("wrong projection:", names)
+	}
+}
+
+func TestRemovePaths(t *testing.T) {
+	t.Log("TestRemovePaths")
+
+	o, err := New(
+		Index[int, int]{Key: "key", Func: CompareByKey[int, int]},
+		WithRecordPool[int, int](true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 10 {
+		o.Set(i, i/2)
+	}
+
+	// Count removals in journal
+	var journal strings.Builder
+	o.Journal(&journal, func(op string, key int, data int) []byte {
+		if op != JournalDel {
+			return nil
+		}
+		return fmt.Appendf(nil, "%d,", key)
+	})
+
+	// Remove records by every removal path
+	o.Del(0)
+	o.DelLast()
+	o.SetFirstLimit(10, 5, 8)
+	o.Reap(func(key, data int) bool { return key == 5 }, func(key, data int) {})
+	o.CompactFunc(func(a, b int) bool { return a == b })
+	o.TrimFront(3)
+	o.TrimBack(2)
+	for range o.Drain() {
+		break
+	}
+
+	if err = o.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if journal.String() != "0,9,8,5,3,7,6,4,10,1," || o.Len() != 1 {
+		t.Fatal("wrong removals:", journal.String(), o.Len())
+	}
+}
+
+func TestForEachShuffled(t *testing.T) {
+	t.Log("TestForEachShuffled")
+
+	o, err := New[int, int]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 20 {
+		o.Set(i, i)
+	}
+
+	shuffled := func(seed int64) (keys []int) {
+		o.ForEachShuffled(seed, func(key, data int) {
+			keys = append(keys, key)
+		})
+		return
+	}
+
+	// Same seed gives same order, other seed gives other order
+	keys1, keys2, keys3 := shuffled(1), shuffled(1), shuffled(2)
+	if len(keys1) != 20 || !slices.Equal(keys1, keys2) {
+		t.Fatal("wrong order for same seed:", keys1, keys2)
+	}
+	if slices.Equal(keys1, keys3) {
+		t.Fatal("same order for other seed:", keys1)
+	}
+	slices.Sort(keys3)
+	if keys3[0] != 0 || keys3[19] != 19 || len(slices.Compact(keys3)) != 20 {
+		t.Fatal("wrong keys:", keys3)
+	}
+}
+
+func TestClearAll(t *testing.T) {
+	t.Log("TestClearAll")
+
+	o, err := New(
+		Index[string, *Person]{Key: "name", Func: CompareByName},
+		Index[string, *Person]{Key: "age", Func: CompareByAgeAsc},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set("john", &Person{Name: "John", Age: 30})
+
+	// Clear keeps indexes
+	o.Clear()
+	if keys := o.IndexKeys(); len(keys) != 2 || o.Len() != 0 {
+		t.Fatal("wrong index keys after Clear:", keys, o.Len())
+	}
+
+	// ClearAll drops indexes
+	o.Set("john", &Person{Name: "John", Age: 30})
+	o.ClearAll()
+	if keys := o.IndexKeys(); len(keys) != 0 || o.Len() != 0 {
+		t.Fatal("wrong index keys after ClearAll:", keys, o.Len())
+	}
+	o.Set("jane", &Person{Name: "Jane", Age: 20})
+	if err = o.Validate(); err != nil || o.Idx.First("name") != nil {
+		t.Fatal("wrong map after ClearAll:", err)
+	}
+}
+
+func TestTopK(t *testing.T) {
+	t.Log("TestTopK")
+
+	o, err := New(Index[string, int]{Key: "score",
+		Func: CompareByValueThenKey[string, int]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, score := range map[string]int{"a": 50, "b": 10, "c": 40, "d": 20} {
+		o.Set(key, score)
+	}
+
+	if keys := pairKeys(o.TopK("score", 2)); keys != "b,d" {
+		t.Fatal("wrong top:", keys)
+	}
+	if keys := pairKeys(o.BottomK("score", 3)); keys != "a,c,d" {
+		t.Fatal("wrong bottom:", keys)
+	}
+	if pairs := o.TopK("score", 10); len(pairs) != 4 {
+		t.Fatal("wrong top length:", len(pairs))
+	}
+	if pairs := o.TopK("unknown", 2); pairs != nil {
+		t.Fatal("pairs of unknown index:", pairs)
+	}
+}
+
+func TestWithKeyTransform(t *testing.T) {
+	t.Log("TestWithKeyTransform")
+
+	o, err := New(
+		Index[string, int]{Key: "key", Func: CompareByKey[string, int]},
+		WithKeyTransform[string, int](func(key string) string {
+			return strings.ToLower(strings.TrimSpace(key))
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o.Set(" Bob ", 1)
+	o.Set("alice", 2)
+	o.Set("ALICE ", 3) // same key as alice
+
+	if keys := pairKeys(o.Pairs("key")); keys != "alice,bob" {
+		t.Fatal("wrong keys:", keys)
+	}
+	if data, ok := o.Get("Alice"); !ok || data != 3 {
+		t.Fatal("wrong data:", data, ok)
+	}
+	if _, ok := o.Del(" BOB"); !ok || o.Len() != 1 {
+		t.Fatal("wrong delete:", ok, o.Len())
+	}
+	if err = o.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDrainChan(t *testing.T) {
+	t.Log("TestDrainChan")
+
+	o, err := New[int, int]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 5 {
+		o.Set(i, i)
+	}
+
+	// Drain whole map
+	ch, stop := o.DrainChan(0)
+	defer stop()
+	var keys []int
+	for pair := range ch {
+		keys = append(keys, pair.Key)
+	}
+	if !slices.Equal(keys, []int{0, 1, 2, 3, 4}) || o.Len() != 0 {
+		t.Fatal("wrong drain:", keys, o.Len())
+	}
+
+	// Stop draining early
+	for i := range 5 {
+		o.Set(i, i)
+	}
+	ch, stop = o.DrainChan(0)
+	if pair := <-ch; pair.Key != 0 {
+		t.Fatal("wrong first pair:", pair)
+	}
+	stop()
+	for range ch {
+	}
+	if l := o.Len(); l != 4 {
+		t.Fatal("wrong length after stop:", l)
+	}
+
+	// Buffered pairs are removed, so read them after stop
+	o.Clear()
+	for i := range 10 {
+		o.Set(i, i)
+	}
+	ch, stop = o.DrainChan(5)
+	keys = []int{(<-ch).Key}
+	stop()
+	for pair := range ch {
+		keys = append(keys, pair.Key)
+	}
+	if len(keys)+o.Len() != 10 || !slices.Equal(keys, []int{0, 1, 2, 3, 4,
+		5, 6, 7, 8, 9}[:len(keys)]) {
+		t.Fatal("wrong buffered drain:", keys, o.Len())
+	}
+	if first := o.Idx.First(); first != nil && first.Key() != len(keys) {
+		t.Fatal("wrong first kept record:", first.Key())
+	}
+}
+
+func TestCompareByKeyDesc(t *testing.T) {
+	t.Log("TestCompareByKeyDesc")
+
+	o, err := New(Index[string, int]{Key: "desc",
+		Func: CompareByKeyDesc[string, int]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range []string{"b", "d", "a", "c"} {
+		o.Set(key, i)
+	}
+	if keys := pairKeys(o.Pairs("desc")); keys != "d,c,b,a" {
+		t.Fatal("wrong order:", keys)
+	}
+
+	// Exact negation of CompareByKey
+	a, b := NewRecord("a", 0), NewRecord("b", 0)
+	for _, r := range [][2]*Record[string, int]{{a, b}, {b, a}, {a, a}} {
+		if CompareByKeyDesc(r[0], r[1]) != -CompareByKey(r[0], r[1]) {
+			t.Fatal("not negation:", r[0].Key(), r[1].Key())
+		}
+	}
+}
+
+func TestLastSortMoved(t *testing.T) {
+	t.Log("TestLastSortMoved")
+
+	o, err := New(Index[int, int]{Key: "value",
+		Func: CompareByValueThenKey[int, int]})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Update of record moves it to its new position
+	for i := range 5 {
+		o.Set(i, i)
+	}
+	o.Set(0, 10)
+	if moves := o.LastSortMoved("value"); moves != 1 {
+		t.Fatal("wrong moves after Set:", moves)
+	}
+
+	// Stable data is not moved
+	o.Refresh()
+	if moves := o.LastSortMoved("value"); moves != 0 {
+		t.Fatal("wrong moves after Refresh:", moves)
+	}
+	if moves := o.LastSortMoved("unknown"); moves != 0 {
+		t.Fatal("wrong moves of unknown index:", moves)
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	t.Log("TestBuilder")
+
+	b := NewBuilder(
+		Index[string, *Person]{Key: "name", Func: CompareByName},
+		Index[string, *Person]{Key: "age", Func: CompareByAgeAsc},
+	)
+	b.Add("john", &Person{Name: "John", Age: 30})
+	b.Add("jane", &Person{Name: "Jane", Age: 20})
+	b.Add("bob", &Person{Name: "Bob", Age: 25})
+	b.Add("john", &Person{Name: "John", Age: 35})
+
+	o, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = o.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if keys := pairKeys(o.Pairs()); keys != "john,jane,bob" {
+		t.Fatal("wrong insertion order:", keys)
+	}
+	if keys := pairKeys(o.Pairs("age")); keys != "jane,bob,john" {
+		t.Fatal("wrong age order:", keys)
+	}
+
+	// Writes to built map use the normal path
+	o.Set("alice", &Person{Name: "Alice", Age: 22})
+	if keys := pairKeys(o.Pairs("name")); keys != "alice,bob,jane,john" {
+		t.Fatal("wrong name order:", keys)
+	}
+}
+
+func TestSetAll(t *testing.T) {
+	t.Log("TestSetAll")
+
+	src, err := New[string, int]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range []string{"d", "b", "a", "c"} {
+		src.Set(key, i)
+	}
+
+	o, err := New(Index[string, int]{Key: "key", Func: CompareByKey[string, int]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set("b", 10)
+
+	// Pipe records of other map and of Go map
+	if err = o.SetAll(src.Records()); err != nil {
+		t.Fatal(err)
+	}
+	if err = o.SetAll(maps.All(map[string]int{"e": 5})); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = o.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if keys := pairKeys(o.Pairs()); keys != "b,d,a,c,e" {
+		t.Fatal("wrong insertion order:", keys)
+	}
+	if keys := pairKeys(o.Pairs("key")); keys != "a,b,c,d,e" {
+		t.Fatal("wrong key order:", keys)
+	}
+	if data, _ := o.Get("b"); data != 1 {
+		t.Fatal("wrong updated data:", data)
+	}
+}
+
+func TestRefreshDefaultIndex(t *testing.T) {
+	t.Log("TestRefreshDefaultIndex")
+
+	for _, sorted := range []bool{false, true} {
+		o, err := New[string, *Person]()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sorted {
+			o.SetDefaultComparator(CompareByAgeAsc)
+		}
+		o.Set("jane", &Person{Name: "Jane", Age: 20})
+		o.Set("john", &Person{Name: "John", Age: 30})
+
+		// Change data directly and refresh
+		o.Lock()
+		if p, ok := o.Get("jane", true); ok {
+			p.Age = 40
+		}
+		o.Unlock()
+		o.Refresh()
+
+		// Default index is sorted by age or keeps insertion order
+		expected := "jane,john"
+		if sorted {
+			expected = "john,jane"
+		}
+		if keys := pairKeys(o.Pairs()); keys != expected {
+			t.Fatal("wrong order, sorted:", sorted, keys)
+		}
+	}
+}
+
+func TestMapAll(t *testing.T) {
+	t.Log("TestMapAll")
+
+	o, err := New(Index[string, *Person]{Key: "name", Func: CompareByName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set("john", &Person{Name: "John", Age: 30})
+	o.Set("jane", &Person{Name: "Jane", Age: 20})
+
+	ages := MapAll(o, func(key string, p *Person) int { return p.Age })
+	if err = ages.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if keys := pairKeys(ages.Pairs()); keys != "john,jane" {
+		t.Fatal("wrong order:", keys)
+	}
+	if age, _ := ages.Get("jane"); age != 20 {
+		t.Fatal("wrong data:", age)
+	}
+	if keys := ages.IndexKeys(); len(keys) != 0 {
+		t.Fatal("indexes carried over:", keys)
+	}
+}
+
+func TestUpdateMany(t *testing.T) {
+	t.Log("TestUpdateMany")
+
+	o, err := New(Index[string, int]{Key: "value",
+		Func: CompareByValueThenKey[string, int]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set("a", 1)
+	o.Set("b", 2)
+
+	applied := o.UpdateMany(map[string]int{"a": 30, "c": 10, "d": 20})
+	if err = o.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if keys := pairKeys(o.Pairs("value")); applied != 3 || keys != "b,c,d,a" {
+		t.Fatal("wrong update:", applied, keys)
+	}
+}
+
+func TestAdjacent(t *testing.T) {
+	t.Log("TestAdjacent")
+
+	o, err := New(Index[string, int]{Key: "value",
+		Func: CompareByValueThenKey[string, int]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set("a", 3)
+	o.Set("b", 1)
+	o.Set("c", 2)
+	a, _ := o.GetRecord("a")
+	b, _ := o.GetRecord("b")
+	c, _ := o.GetRecord("c")
+
+	// Insertion order a,b,c and value order b,c,a
+	if ab, ba := o.Adjacent(0, a, b); !ab || ba {
+		t.Fatal("wrong default adjacency:", ab, ba)
+	}
+	if ab, ba := o.Adjacent("value", a, c); ab || !ba {
+		t.Fatal("wrong value adjacency:", ab, ba)
+	}
+	if ab, ba := o.Adjacent("value", a, b); ab || ba {
+		t.Fatal("wrong value adjacency of not neighbors:", ab, ba)
+	}
+
+	// Record got from other index
+	if ab, _ := o.Adjacent("value", o.Idx.First("value"), c); !ab {
+		t.Fatal("wrong adjacency of record from value index")
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	t.Log("TestMarshalJSON")
+
+	// String keys are encoded to object
+	o, err := New(Index[string, *Person]{Key: "age", Func: CompareByAgeAsc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set("john", &Person{Name: "John", Age: 30})
+	o.Set("jane", &Person{Name: "Jane", Age: 20})
+	data, err := json.Marshal(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"john":{"Name":"John","Age":30},"jane":{"Name":"Jane","Age":20}}`
+	if string(data) != expected {
+		t.Fatal("wrong json:", string(data))
+	}
+
+	// Decode to map with indexes
+	o2, _ := New(Index[string, *Person]{Key: "age", Func: CompareByAgeAsc})
+	o2.Set("old", &Person{})
+	if err = json.Unmarshal(data, o2); err != nil {
+		t.Fatal(err)
+	}
+	if keys := pairKeys(o2.Pairs()); keys != "john,jane" {
+		t.Fatal("wrong decoded order:", keys)
+	}
+	if keys := pairKeys(o2.Pairs("age")); keys != "jane,john" {
+		t.Fatal("wrong decoded age order:", keys)
+	}
+
+	// Integer keys are encoded to object, other keys to array
+	var ints Omap[int, string]
+	if err = json.Unmarshal([]byte(`{"3":"c","1":"a"}`), &ints); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ = json.Marshal(&ints); string(data) != `{"3":"c","1":"a"}` {
+		t.Fatal("wrong int keys json:", string(data))
+	}
+	floats, _ := New[float64, int]()
+	if data, _ = json.Marshal(floats); string(data) != `[]` {
+		t.Fatal("wrong empty array json:", string(data))
+	}
+	floats.Set(1.5, 1)
+	if data, _ = json.Marshal(floats); string(data) != `[{"key":1.5,"value":1}]` {
+		t.Fatal("wrong array json:", string(data))
+	}
+	o.Clear()
+	if data, _ = json.Marshal(o); string(data) != `{}` {
+		t.Fatal("wrong empty object json:", string(data))
+	}
+}
+
+func TestGob(t *testing.T) {
+	t.Log("TestGob")
+
+	o, err := New[string, *Person]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set("john", &Person{Name: "John", Age: 30})
+	o.Set("jane", &Person{Name: "Jane", Age: 20})
+	o.Set("bob", &Person{Name: "Bob", Age: 25})
+
+	var buf bytes.Buffer
+	if err = gob.NewEncoder(&buf).Encode(o); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// Decode to zero map
+	var zero Omap[string, *Person]
+	if err = gob.NewDecoder(bytes.NewReader(data)).Decode(&zero); err != nil {
+		t.Fatal(err)
+	}
+	if keys := pairKeys(zero.Pairs()); keys != "john,jane,bob" {
+		t.Fatal("wrong decoded order:", keys)
+	}
+
+	// Decode to map with index
+	o2, _ := New(Index[string, *Person]{Key: "age", Func: CompareByAgeAsc})
+	if err = gob.NewDecoder(bytes.NewReader(data)).Decode(o2); err != nil {
+		t.Fatal(err)
+	}
+	if err = o2.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if keys := pairKeys(o2.Pairs("age")); keys != "jane,bob,john" {
+		t.Fatal("wrong decoded age order:", keys)
+	}
+}
+
+func TestKeysValues(t *testing.T) {
+	t.Log("TestKeysValues")
+
+	o, err := New(Index[string, int]{Key: "key", Func: CompareByKey[string, int]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys, values := o.Keys(), o.Values(); keys == nil || values == nil {
+		t.Fatal("nil slices for empty map")
+	}
+	for i, key := range []string{"c", "a", "b"} {
+		o.Set(key, i)
+	}
+
+	if keys := o.Keys(); !slices.Equal(keys, []string{"c", "a", "b"}) {
+		t.Fatal("wrong keys:", keys)
+	}
+	if values := o.Values("key"); !slices.Equal(values, []int{1, 2, 0}) {
+		t.Fatal("wrong values:", values)
+	}
+}
+
+func TestInsertSorted(t *testing.T) {
+	t.Log("TestInsertSorted")
+
+	o, err := New(
+		Index[int, int]{Key: "key", Func: CompareByKey[int, int]},
+		Index[int, int]{Key: "desc", Func: CompareByKeyDesc[int, int]},
+		Index[int, int]{Key: "value", Func: CompareByValueThenKey[int, int]},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Insert records in random order, update some of them
+	r := rand.New(rand.NewPCG(1, 2))
+	for range 100 {
+		o.Set(r.IntN(50), r.IntN(10))
+	}
+	if err = o.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClone(t *testing.T) {
+	t.Log("TestClone")
+
+	var lastCtx *Omap[string, *Person]
+	o, err := New(
+		Index[string, *Person]{Key: "age", Func: CompareByAgeAsc},
+		Index[string, *Person]{Key: "name", FuncCtx: func(r1,
+			r2 *Record[string, *Person], ctx *Omap[string, *Person]) int {
+			lastCtx = ctx
+			return CompareByName(r1, r2)
+		}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set("john", &Person{Name: "John", Age: 30})
+	o.Set("jane", &Person{Name: "Jane", Age: 20})
+
+	// Shallow copy shares data
+	c := o.Clone()
+	if err = c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if keys := pairKeys(c.Pairs("age")); keys != "jane,john" {
+		t.Fatal("wrong clone age order:", keys)
+	}
+	c.Set("bob", &Person{Name: "Bob", Age: 25})
+	if keys := pairKeys(c.Pairs("name")); keys != "bob,jane,john" || o.Len() != 2 {
+		t.Fatal("wrong clone name order:", keys, o.Len())
+	}
+	if lastCtx != c {
+		t.Fatal("clone sort function got wrong context")
+	}
+	john, _ := c.Get("john")
+	if orig, _ := o.Get("john"); orig != john {
+		t.Fatal("data not shared")
+	}
+
+	// Deep copy does not share data
+	d := o.CloneFunc(func(p *Person) *Person {
+		cp := *p
+		return &cp
+	})
+	john, _ = d.Get("john")
+	john.Age = 10
+	d.Refresh()
+	if orig, _ := o.Get("john"); orig.Age != 30 || pairKeys(d.Pairs("age")) != "john,jane" {
+		t.Fatal("data shared by deep copy")
+	}
+}
+
+func TestRemoveIndex(t *testing.T) {
+	t.Log("TestRemoveIndex")
+
+	o, err := New(
+		Index[string, *Person]{Key: "name", Func: CompareByName},
+		Index[string, *Person]{Key: "age", Func: CompareByAgeAsc},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set("john", &Person{Name: "John", Age: 30})
+
+	if err = o.Idx.RemoveIndex("age"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []any{"age", 0, "unknown"} {
+		if err = o.Idx.RemoveIndex(key); err != ErrIncorrectIndexKey {
+			t.Fatal("wrong error for index", key, err)
+		}
+	}
+
+	// Removed index iterates no records
+	o.Set("jane", &Person{Name: "Jane", Age: 20})
+	if pairs := o.Pairs("age"); len(pairs) != 0 {
+		t.Fatal("pairs of removed index:", pairs)
+	}
+	if keys := o.IndexKeys(); len(keys) != 1 || keys[0] != "name" {
+		t.Fatal("wrong index keys:", keys)
+	}
+	o.Del("john")
+	if err = o.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddIndex(t *testing.T) {
+	t.Log("TestAddIndex")
+
+	o, err := New(Index[string, *Person]{Key: "name", Func: CompareByName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set("john", &Person{Name: "John", Age: 30})
+	o.Set("jane", &Person{Name: "Jane", Age: 20})
+	o.Set("bob", &Person{Name: "Bob", Age: 25})
+
+	// Add index after data was loaded
+	if err = o.Idx.AddIndex(Index[string, *Person]{Key: "age",
+		Func: CompareByAgeAsc}); err != nil {
+		t.Fatal(err)
+	}
+	if keys := pairKeys(o.Pairs("age")); keys != "jane,bob,john" {
+		t.Fatal("wrong age order:", keys)
+	}
+	o.Set("alice", &Person{Name: "Alice", Age: 22})
+	if err = o.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Add default and existing index
+	for _, key := range []any{0, "name", "age"} {
+		err = o.Idx.AddIndex(Index[string, *Person]{Key: key, Func: CompareByName})
+		if err != ErrIncorrectIndexKey {
+			t.Fatal("wrong error for index", key, err)
+		}
+	}
+}
+
+func TestGetOrSet(t *testing.T) {
+	t.Log("TestGetOrSet")
+
+	o, err := New[string, int]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, loaded, err := o.GetOrSet("a", 1); loaded || data != 1 || err != nil {
+		t.Fatal("wrong set:", data, loaded, err)
+	}
+	if data, loaded, err := o.GetOrSet("a", 2); !loaded || data != 1 || err != nil {
+		t.Fatal("wrong get:", data, loaded, err)
+	}
+
+	// Initialize key concurrently, function is called once
+	var calls atomic.Int32
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Go(func() {
+			o.GetOrSetFunc("b", func() int {
+				calls.Add(1)
+				return 10
+			})
+		})
+	}
+	wg.Wait()
+	if data, _ := o.Get("b"); calls.Load() != 1 || data != 10 {
+		t.Fatal("wrong concurrent set:", calls.Load(), data)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	t.Log("TestUpdate")
+
+	o, err := New(Index[string, int]{Key: "value",
+		Func: CompareByValueThenKey[string, int]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	incr := func(old int, exists bool) (int, bool) { return old + 1, true }
+
+	// Count concurrently
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Go(func() {
+			for range 100 {
+				o.Update("a", incr)
+			}
+		})
+	}
+	wg.Wait()
+	o.Update("b", incr)
+	if data, _ := o.Get("a"); data != 1000 {
+		t.Fatal("wrong counter:", data)
+	}
+	if keys := pairKeys(o.Pairs("value")); keys != "b,a" {
+		t.Fatal("wrong value order:", keys)
+	}
+
+	// Update without store
+	data, stored := o.Update("b", func(old int, exists bool) (int, bool) {
+		return 100, false
+	})
+	if data != 1 || stored {
+		t.Fatal("wrong not stored update:", data, stored)
+	}
+	data, stored = o.Update("c", func(old int, exists bool) (int, bool) {
+		return old, exists
+	})
+	if stored || o.Exists("c") {
+		t.Fatal("not existing key stored:", data, stored)
+	}
+}
+
+func TestRecordsReverse(t *testing.T) {
+	t.Log("TestRecordsReverse")
+
+	o, err := New(Index[string, int]{Key: "key", Func: CompareByKey[string, int]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range []string{"b", "c", "a", "d"} {
+		o.Set(key, i)
+	}
+
+	var keys []string
+	for key := range o.RecordsReverse() {
+		keys = append(keys, key)
+	}
+	if strings.Join(keys, ",") != "d,a,c,b" {
+		t.Fatal("wrong reverse order:", keys)
+	}
+
+	// Custom index and early stop
+	keys = keys[:0]
+	for key := range o.RecordsReverse("key") {
+		if key == "b" {
+			break
+		}
+		keys = append(keys, key)
+	}
+	if strings.Join(keys, ",") != "d,c" {
+		t.Fatal("wrong reverse key order:", keys)
+	}
+}
+
+func TestFirstLastN(t *testing.T) {
+	t.Log("TestFirstLastN")
+
+	o, err := New(Index[string, int]{Key: "key", Func: CompareByKey[string, int]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range []string{"d", "a", "c", "b", "e"} {
+		o.Set(key, i)
+	}
+
+	if keys := pairKeys(o.FirstN(2)); keys != "d,a" {
+		t.Fatal("wrong first:", keys)
+	}
+	if keys := pairKeys(o.LastN(2)); keys != "b,e" {
+		t.Fatal("wrong last:", keys)
+	}
+	if keys := pairKeys(o.FirstN(3, "key")); keys != "a,b,c" {
+		t.Fatal("wrong first by key:", keys)
+	}
+	if keys := pairKeys(o.LastN(10, "key")); keys != "a,b,c,d,e" {
+		t.Fatal("wrong last by key:", keys)
+	}
+	for _, pairs := range [][]Pair[string, int]{o.FirstN(0), o.LastN(-1)} {
+		if pairs == nil || len(pairs) != 0 {
+			t.Fatal("wrong empty result:", pairs)
+		}
+	}
+}
+
+func TestSlice(t *testing.T) {
+	t.Log("TestSlice")
+
+	o, err := New(Index[string, int]{Key: "key", Func: CompareByKey[string, int]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range []string{"d", "a", "c", "b", "e"} {
+		o.Set(key, i)
+	}
+
+	for _, test := range []struct {
+		offset, limit int
+		idxKey        []any
+		keys          string
+	}{
+		{0, 2, nil, "d,a"},
+		{1, 3, []any{"key"}, "b,c,d"},
+		{3, -1, []any{"key"}, "d,e"},
+		{4, 10, nil, "e"},
+		{5, 1, nil, ""},
+		{-1, 1, nil, ""},
+		{0, 0, nil, ""},
+	} {
+		pairs := o.Slice(test.offset, test.limit, test.idxKey...)
+		if pairs == nil || pairKeys(pairs) != test.keys {
+			t.Fatal("wrong slice:", test.offset, test.limit, pairs)
+		}
+	}
+}
+
+func TestRank(t *testing.T) {
+	t.Log("TestRank")
+
+	o, err := New(Index[string, int]{Key: "score",
+		Func: CompareByValueThenKey[string, int]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, score := range map[string]int{"a": 50, "b": 10, "c": 40, "d": 20} {
+		o.Set(key, score)
+	}
+
+	rec, _ := o.GetRecord("c")
+	if rank, ok := o.Idx.Rank(rec, "score"); !ok || rank != 2 {
+		t.Fatal("wrong rank:", rank, ok)
+	}
+	if rank, ok := o.RankByKey("a", "score"); !ok || rank != 3 {
+		t.Fatal("wrong rank by key:", rank, ok)
+	}
+	if _, ok := o.RankByKey("x", "score"); ok {
+		t.Fatal("rank of missing key")
+	}
+	if _, ok := o.Idx.Rank(rec, "unknown"); ok {
+		t.Fatal("rank in unknown index")
+	}
+	if _, ok := o.Idx.Rank(NewRecord("c", 40), "score"); ok {
+		t.Fatal("rank of foreign record")
+	}
+}
+
+func TestFind(t *testing.T) {
+	t.Log("TestFind")
+
+	o, err := New(Index[string, int]{Key: "score",
+		Func: CompareByValueThenKey[string, int]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, score := range map[string]int{"a": 50, "b": 10, "c": 40, "d": 20} {
+		o.Set(key, score)
+	}
+
+	var checked int
+	rec, ok := o.Find(func(rec *Record[string, int]) bool {
+		checked++
+		return rec.Data() > 15
+	}, "score")
+	if !ok || rec.Key() != "d" || checked != 2 {
+		t.Fatal("wrong found record:", rec, ok, checked)
+	}
+	if rec, ok := o.Find(func(rec *Record[string, int]) bool {
+		return rec.Data() > 100
+	}); ok || rec != nil {
+		t.Fatal("found record which does not match:", rec)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	t.Log("TestFilter")
+
+	o, err := New(Index[string, int]{Key: "key", Func: CompareByKey[string, int]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range []string{"d", "a", "c", "b", "e"} {
+		o.Set(key, i)
+	}
+
+	f := o.Filter(func(key string, data int) bool { return data%2 == 0 })
+	if keys := pairKeys(f.Pairs()); keys != "d,c,e" {
+		t.Fatal("wrong filtered keys:", keys)
+	}
+	if keys := pairKeys(f.Pairs("key")); keys != "c,d,e" {
+		t.Fatal("wrong filtered index keys:", keys)
+	}
+
+	// Filtered map is independent
+	f.Set("f", 6)
+	f.Del("d")
+	if o.Len() != 5 || o.Exists("f") || !o.Exists("d") {
+		t.Fatal("source map changed")
+	}
+	if err := f.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCount(t *testing.T) {
+	t.Log("TestCount")
+
+	o, err := New[int, int]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 10 {
+		o.Set(i, i*i)
+	}
+
+	if n := o.Count(func(key, data int) bool { return data > 20 }); n != 5 {
+		t.Fatal("wrong count:", n)
+	}
+	if n := o.Count(nil); n != o.Len() {
+		t.Fatal("wrong count without predicate:", n)
+	}
+}
+
+func TestSetMany(t *testing.T) {
+	t.Log("TestSetMany")
+
+	o, err := New(Index[string, int]{Key: "score",
+		Func: CompareByValueThenKey[string, int]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set("a", 50)
+
+	err = o.SetMany([]Pair[string, int]{{"b", 10}, {"c", 40}, {"a", 5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := pairKeys(o.Pairs()); keys != "a,b,c" {
+		t.Fatal("wrong keys:", keys)
+	}
+	if keys := pairKeys(o.Pairs("score")); keys != "a,b,c" {
+		t.Fatal("wrong score keys:", keys)
+	}
+
+	err = o.SetManyFirst([]Pair[string, int]{{"d", 20}, {"e", 30}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := pairKeys(o.Pairs()); keys != "e,d,a,b,c" {
+		t.Fatal("wrong keys after SetManyFirst:", keys)
+	}
+	if keys := pairKeys(o.Pairs("score")); keys != "a,b,d,e,c" {
+		t.Fatal("wrong score keys after SetManyFirst:", keys)
+	}
+}
+
+func TestDelMany(t *testing.T) {
+	t.Log("TestDelMany")
+
+	o, err := New(Index[string, int]{Key: "key", Func: CompareByKey[string, int]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range []string{"d", "a", "c", "b", "e"} {
+		o.Set(key, i)
+	}
+
+	if n := o.DelMany([]string{"a", "x", "e", "a"}); n != 2 {
+		t.Fatal("wrong deleted number:", n)
+	}
+	if keys := pairKeys(o.Pairs("key")); keys != "b,c,d" {
+		t.Fatal("wrong keys:", keys)
+	}
+	if err := o.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	t.Log("TestMerge")
+
+	newMap := func(pairs ...Pair[string, int]) *Omap[string, int] {
+		o, err := New(Index[string, int]{Key: "score",
+			Func: CompareByValueThenKey[string, int]})
+		if err != nil {
+			t.Fatal(err)
+		}
+		o.SetMany(pairs)
+		return o
+	}
+
+	for _, overwrite := range []bool{false, true} {
+		o := newMap(Pair[string, int]{"a", 10}, Pair[string, int]{"b", 20})
+		err := o.Merge(newMap(Pair[string, int]{"c", 5},
+			Pair[string, int]{"a", 30}), overwrite)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		score, keys := "c,a,b", "a,b,c"
+		if overwrite {
+			score = "c,b,a"
+		}
+		if k := pairKeys(o.Pairs()); k != keys {
+			t.Fatal("wrong keys:", k, overwrite)
+		}
+		if k := pairKeys(o.Pairs("score")); k != score {
+			t.Fatal("wrong score keys:", k, overwrite)
+		}
+	}
+
+	// Merge into itself
+	o := newMap(Pair[string, int]{"a", 10}, Pair[string, int]{"b", 20})
+	if err := o.Merge(o, true); err != nil {
+		t.Fatal(err)
+	}
+	if k := pairKeys(o.Pairs()); k != "a,b" {
+		t.Fatal("wrong keys after self merge:", k)
+	}
+}
+
+func TestPop(t *testing.T) {
+	t.Log("TestPop")
+
+	o, err := New(Index[string, int]{Key: "score",
+		Func: CompareByValueThenKey[string, int]}, WithRecordPool[string, int](true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.SetMany([]Pair[string, int]{{"a", 30}, {"b", 10}, {"c", 20}, {"d", 40}})
+
+	var keys []string
+	for {
+		rec, data, ok := o.PopFirst("score")
+		if !ok {
+			break
+		}
+		if rec.Data() != data {
+			t.Fatal("wrong popped record:", rec.Key(), rec.Data(), data)
+		}
+		keys = append(keys, rec.Key())
+	}
+	if strings.Join(keys, ",") != "b,c,a,d" {
+		t.Fatal("wrong popped keys:", keys)
+	}
+
+	o.SetMany([]Pair[string, int]{{"a", 30}, {"b", 10}})
+	if rec, _, ok := o.PopLast(); !ok || rec.Key() != "b" {
+		t.Fatal("wrong last record:", rec, ok)
+	}
+	if rec, _, ok := o.PopLast("score"); !ok || rec.Key() != "a" {
+		t.Fatal("wrong last score record:", rec, ok)
+	}
+	if _, _, ok := o.PopLast(); ok {
+		t.Fatal("record popped from empty map")
+	}
+}
+
+func TestString(t *testing.T) {
+	t.Log("TestString")
+
+	o, err := New[string, int]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := o.String(); s != "[]" {
+		t.Fatal("wrong empty map string:", s)
+	}
+	o.Set("b", 1)
+	o.Set("a", 2)
+	if s := fmt.Sprint(o); s != "[b:1 a:2]" {
+		t.Fatal("wrong map string:", s)
+	}
+
+	n, _ := New[int, int]()
+	for i := range stringLimit + 5 {
+		n.Set(i, i)
+	}
+	if s := n.String(); !strings.HasSuffix(s, "99:99 ...(5 more)]") {
+		t.Fatal("wrong limited map string:", s[len(s)-30:])
+	}
+}
+
+func TestEqual(t *testing.T) {
+	t.Log("TestEqual")
+
+	newMap := func(keys ...string) *Omap[string, int] {
+		o, err := New[string, int]()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, key := range keys {
+			o.Set(key, i)
+		}
+		return o
+	}
+
+	o := newMap("a", "b", "c")
+	if !EqualComparable(o, newMap("a", "b", "c")) || !EqualComparable(o, o) {
+		t.Fatal("equal maps are not equal")
+	}
+	for _, other := range []*Omap[string, int]{
+		newMap("a", "b"), newMap("a", "c", "b"), newMap("a", "b", "x"),
+	} {
+		if EqualComparable(o, other) {
+			t.Fatal("different maps are equal:", other)
+		}
+	}
+
+	other := newMap("a", "b", "c")
+	other.Set("c", 5)
+	if EqualComparable(o, other) {
+		t.Fatal("maps with different data are equal")
+	}
+	if !o.Equal(other, func(a, b int) bool { return a%3 == b%3 }) {
+		t.Fatal("maps are not equal by function")
+	}
+}
+
+func TestToMap(t *testing.T) {
+	t.Log("TestToMap")
+
+	o, err := New[string, int]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set("a", 1)
+	o.Set("b", 2)
+
+	m := o.ToMap()
+	if !maps.Equal(m, map[string]int{"a": 1, "b": 2}) {
+		t.Fatal("wrong map:", m)
+	}
+
+	// Returned map is detached
+	m["c"] = 3
+	if o.Exists("c") {
+		t.Fatal("map is not detached")
+	}
+}
+
+func TestRange(t *testing.T) {
+	t.Log("TestRange")
+
+	o, err := New(Index[string, int]{Key: "key", Func: CompareByKey[string, int]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range []string{"2025-03", "2025-01", "2025-05", "2025-02",
+		"2025-04"} {
+		o.Set(key, i)
+	}
+
+	if keys := pairKeys(o.Idx.Range("2025-02", "2025-04", "key")); keys !=
+		"2025-02,2025-03,2025-04" {
+		t.Fatal("wrong range:", keys)
+	}
+	if keys := pairKeys(o.Idx.Range("2025-02x", "2025-09", "key")); keys !=
+		"2025-03,2025-04,2025-05" {
+		t.Fatal("wrong open range:", keys)
+	}
+	if pairs := o.Idx.Range("2025-06", "2025-09", "key"); pairs == nil ||
+		len(pairs) != 0 {
+		t.Fatal("wrong empty range:", pairs)
+	}
+	if pairs := o.Idx.Range("2025-01", "2025-09"); pairs != nil {
+		t.Fatal("range of index without sort function:", pairs)
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	t.Log("TestPrefix")
+
+	o, err := New(Index[string, int]{Key: "key", Func: CompareByKey[string, int]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range []string{"a/b/c", "a", "b/a", "a/b", "a/c", "ab"} {
+		o.Set(key, i)
+	}
+
+	if keys := pairKeys(Prefix(o, "a/", "key")); keys != "a/b,a/b/c,a/c" {
+		t.Fatal("wrong prefix keys:", keys)
+	}
+	if keys := pairKeys(Prefix(o, "a", "key")); keys != "a,a/b,a/b/c,a/c,ab" {
+		t.Fatal("wrong prefix keys:", keys)
+	}
+	if pairs := Prefix(o, "c", "key"); pairs == nil || len(pairs) != 0 {
+		t.Fatal("wrong empty prefix pairs:", pairs)
+	}
+	if pairs := Prefix(o, "a/"); pairs != nil {
+		t.Fatal("prefix pairs of not sorted index:", pairs)
+	}
+	if pairs := Prefix(o, "a/", "unknown"); pairs != nil {
+		t.Fatal("prefix pairs of unknown index:", pairs)
+	}
+}
+
+func TestMinMax(t *testing.T) {
+	t.Log("TestMinMax")
+
+	o, err := New(
+		Index[string, *Person]{Key: "AgeAsc", Func: CompareByAgeAsc},
+		Index[string, *Person]{Key: "AgeDesc", Func: CompareByAgeDesc},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := o.Idx.Min("AgeAsc"); ok {
+		t.Fatal("min of empty index")
+	}
+	o.Set("a", &Person{Name: "a", Age: 30})
+	o.Set("b", &Person{Name: "b", Age: 20})
+	o.Set("c", &Person{Name: "c", Age: 40})
+
+	// Min and max are got by the sort function, so the least record of
+	// descending index is the oldest one
+	for _, idx := range []string{"AgeAsc", "AgeDesc"} {
+		min, _ := o.Idx.Min(idx)
+		max, _ := o.Idx.Max(idx)
+		want := "b,c"
+		if idx == "AgeDesc" {
+			want = "c,b"
+		}
+		if keys := min.Key() + "," + max.Key(); keys != want {
+			t.Fatal("wrong min and max:", idx, keys)
+		}
+	}
+	if _, ok := o.Idx.Max(); ok {
+		t.Fatal("max of index without sort function")
+	}
+}
+
+func TestCompareByFunc(t *testing.T) {
+	t.Log("TestCompareByFunc")
+
+	age := CompareByFunc[string](func(p *Person) int { return p.Age })
+	o, err := New(
+		Index[string, *Person]{Key: "AgeAsc", Func: age},
+		Index[string, *Person]{Key: "AgeDesc", Func: Reverse(age)},
+		Index[string, *Person]{Key: "KeyDesc", Func: CompareByKeyDesc[string, *Person]},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set("a", &Person{Name: "a", Age: 30})
+	o.Set("b", &Person{Name: "b", Age: 20})
+	o.Set("c", &Person{Name: "c", Age: 40})
+
+	for idx, want := range map[string]string{
+		"AgeAsc":  "b,a,c",
+		"AgeDesc": "c,a,b",
+		"KeyDesc": "c,b,a",
+	} {
+		if keys := pairKeys(o.Pairs(idx)); keys != want {
+			t.Fatal("wrong keys:", idx, keys)
+		}
+	}
+}
+
+func TestUniqueIndex(t *testing.T) {
+	t.Log("TestUniqueIndex")
+
+	o, err := New(
+		Index[string, *Person]{Key: "Name", Func: CompareByName, Unique: true},
+		Index[string, *Person]{Key: "AgeAsc", Func: CompareByAgeAsc},
+		WithTieBreak(CompareByKey[string, *Person]),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set("1", &Person{Name: "alice", Age: 30})
+	o.Set("2", &Person{Name: "bob", Age: 30})
+
+	// Insert and update which duplicate name are rejected
+	if err := o.Set("3", &Person{Name: "bob"}); !errors.Is(err, ErrUniqueConstraint) {
+		t.Fatal("duplicate insert was not rejected:", err)
+	}
+	if err := o.Set("1", &Person{Name: "bob"}); !errors.Is(err, ErrUniqueConstraint) {
+		t.Fatal("duplicate update was not rejected:", err)
+	}
+	first := o.Idx.First()
+	if err := o.Idx.InsertAfter("4", &Person{Name: "alice"}, first); !errors.Is(err,
+		ErrUniqueConstraint) {
+		t.Fatal("duplicate InsertAfter was not rejected:", err)
+	}
+	if data, _ := o.Get("1"); o.Len() != 2 || data.Name != "alice" {
+		t.Fatal("map changed by rejected set")
+	}
+
+	// Clone keeps unique index
+	if err := o.Clone().Set("3", &Person{Name: "bob"}); !errors.Is(err,
+		ErrUniqueConstraint) {
+		t.Fatal("duplicate insert to clone was not rejected:", err)
+	}
+
+	// Update of the record itself and of not unique fields is allowed
+	if err := o.Set("1", &Person{Name: "alice", Age: 31}); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Set("3", &Person{Name: "carol", Age: 30}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unique index added at runtime checks existing records
+	err = o.Idx.AddIndex(Index[string, *Person]{Key: "Age", Func: CompareByAgeAsc,
+		Unique: true})
+	if !errors.Is(err, ErrUniqueConstraint) {
+		t.Fatal("unique index with duplicates was added:", err)
+	}
+	if slices.Contains(o.IndexKeys(), any("Age")) {
+		t.Fatal("rejected index was not removed")
+	}
+	if err := o.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Batch updates are checked before indexes are sorted
+	err = o.SetMany([]Pair[string, *Person]{{"1", &Person{Name: "zed"}},
+		{"4", &Person{Name: "carol"}}})
+	if !errors.Is(err, ErrUniqueConstraint) {
+		t.Fatal("duplicate in batch was not rejected:", err)
+	}
+}
+
+func TestTxn(t *testing.T) {
+	t.Log("TestTxn")
+
+	o, err := New(Index[string, *Person]{Key: "Name", Func: CompareByName,
+		Unique: true}, WithRecordPool[string, *Person](true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{"a", "b", "c", "d"} {
+		o.Set(fmt.Sprint(i), &Person{Name: name})
+	}
+	state := func() string {
+		return fmt.Sprint(o.Keys(), Project(o, func(key string, p *Person) string {
+			return p.Name
+		}, "Name"))
+	}
+	before := state()
+
+	// Changes are journaled and sent to subscribers on successful commit only
+	var jrn strings.Builder
+	o.Journal(&jrn, func(op, key string, p *Person) []byte {
+		return []byte(op + ":" + key + " ")
+	})
+	events, unsubscribe := o.Subscribe(10)
+	defer unsubscribe()
+
+	// Failed commit restores records, data and order
+	tx := o.Begin()
+	tx.Del("1")
+	tx.Del("2")
+	tx.Set("0", &Person{Name: "x"})
+	tx.Set("4", &Person{Name: "e"})
+	tx.Set("5", &Person{Name: "d"}) // duplicate name
+	if err := tx.Commit(); !errors.Is(err, ErrUniqueConstraint) {
+		t.Fatal("commit was not rejected:", err)
+	}
+	if s := state(); s != before {
+		t.Fatal("map was not restored:", s, before)
+	}
+	if err := o.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != ErrTxnDone {
+		t.Fatal("committed twice:", err)
+	}
+	if jrn.Len() != 0 || len(events) != 0 {
+		t.Fatal("failed commit changes were sent:", jrn.String(), len(events))
+	}
+
+	// Rolled back transaction is not applied
+	tx = o.Begin()
+	tx.Del("0")
+	tx.Rollback()
+	if err := tx.Set("0", nil); err != ErrTxnDone {
+		t.Fatal("set after rollback:", err)
+	}
+	if s := state(); s != before {
+		t.Fatal("rolled back transaction changed map:", s)
+	}
+
+	// Successful commit
+	tx = o.Begin()
+	tx.Del("1")
+	tx.Set("0", &Person{Name: "x"})
+	tx.Set("4", &Person{Name: "e"})
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if s := state(); s != "[0 2 3 4] [c d e x]" {
+		t.Fatal("wrong committed map:", s)
+	}
+	if j := jrn.String(); j != "del:1 set:0 set:4 " || len(events) != 2 {
+		t.Fatal("wrong committed changes:", j, len(events))
+	}
+}
+
+func TestSetIfAbsent(t *testing.T) {
+	t.Log("TestSetIfAbsent")
+
+	o, err := New[string, int]()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !o.SetIfAbsent("a", 1) {
+		t.Fatal("absent key was not set")
+	}
+	if o.SetIfAbsent("a", 2) {
+		t.Fatal("existing key was set")
+	}
+	if data, _ := o.Get("a"); data != 1 {
+		t.Fatal("existing data was overwritten:", data)
+	}
+}
+
+func TestDeleteFunc(t *testing.T) {
+	t.Log("TestDeleteFunc")
+
+	o, err := New(Index[int, int]{Key: "desc", Func: CompareByKeyDesc[int, int]},
+		WithRecordPool[int, int](true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 10 {
+		o.Set(i, i)
+	}
+
+	if n := o.DeleteFunc(func(key, data int) bool { return data%3 == 0 }); n != 4 {
+		t.Fatal("wrong removed number:", n)
+	}
+	if keys := fmt.Sprint(o.Keys("desc")); keys != "[8 7 5 4 2 1]" {
+		t.Fatal("wrong keys:", keys)
+	}
+	if err := o.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtremes(t *testing.T) {
+	t.Log("TestExtremes")
+
+	o, err := New(Index[string, int]{Key: "key", Func: CompareByKey[string, int]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ext := range o.Extremes() {
+		if ext[0] != nil || ext[1] != nil {
+			t.Fatal("extremes of empty map are not nil:", ext)
+		}
+	}
+
+	for i, key := range []string{"c", "a", "d", "b"} {
+		o.Set(key, i)
+	}
+	ext := o.Extremes()
+	if len(ext) != 2 {
+		t.Fatal("wrong number of indexes:", len(ext))
+	}
+	if k := ext[0][0].Key() + ext[0][1].Key(); k != "cb" {
+		t.Fatal("wrong default index extremes:", k)
+	}
+	if k := ext["key"][0].Key() + ext["key"][1].Key(); k != "ad" {
+		t.Fatal("wrong key index extremes:", k)
+	}
+}
+
+func TestForEachRecordIndexed(t *testing.T) {
+	t.Log("TestForEachRecordIndexed")
+
+	o, err := New(Index[string, int]{Key: "key", Func: CompareByKey[string, int]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range []string{"c", "a", "b"} {
+		o.Set(key, i)
+	}
+
+	for idx, want := range map[any]string{0: "0c 1a 2b ", "key": "0a 1b 2c "} {
+		var s string
+		o.ForEachRecordIndexed(func(i int, rec *Record[string, int]) {
+			s += fmt.Sprint(i, rec.Key(), " ")
+		}, idx)
+		if s != want {
+			t.Fatal("wrong positions:", idx, s)
+		}
+	}
+}
+
+func TestGetPairRecord(t *testing.T) {
+	t.Log("TestGetPairRecord")
+
+	o, err := New[string, int]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range []string{"a", "b", "c"} {
+		o.Set(key, i)
+	}
+
+	pair, rec, ok := o.GetPairRecord("c")
+	if !ok || pair.Key != "c" || pair.Value != 2 || rec.Key() != "c" {
+		t.Fatal("wrong pair record:", pair, ok)
+	}
+	if _, rec, ok := o.GetPairRecord("x"); ok || rec != nil {
+		t.Fatal("missing key found")
+	}
+
+	// Returned record is live
+	if err := o.Idx.MoveToFront(rec); err != nil {
+		t.Fatal(err)
+	}
+	if keys := pairKeys(o.Pairs()); keys != "c,a,b" {
+		t.Fatal("wrong keys after move:", keys)
+	}
+}
+
+func TestUpdateAndGet(t *testing.T) {
+	t.Log("TestUpdateAndGet")
+
+	o, err := New[string, int]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set("a", 1)
+
+	rec, _ := o.GetRecord("a")
+	if old := rec.UpdateAndGet(2); old != 1 {
+		t.Fatal("wrong previous data:", old)
+	}
+	if data, _ := o.Get("a"); data != 2 || rec.Data() != 2 {
+		t.Fatal("new data was not stored:", data)
+	}
+}
+
+func TestUniqueIndexErrors(t *testing.T) {
+	t.Log("TestUniqueIndexErrors")
+
+	newMap := func(pairs ...Pair[string, int]) *Omap[string, int] {
+		o, err := New(Index[string, int]{Key: "value", Unique: true,
+			Func: func(r1, r2 *Record[string, int]) int {
+				return cmp.Compare(r1.Data(), r2.Data())
+			}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = o.SetMany(pairs); err != nil {
+			t.Fatal(err)
+		}
+		return o
+	}
+
+	// Merge skips violating records and returns error
+	o := newMap(Pair[string, int]{"a", 1})
+	err := o.Merge(newMap(Pair[string, int]{"x", 1}, Pair[string, int]{"y", 2}),
+		true)
+	if !errors.Is(err, ErrUniqueConstraint) || pairKeys(o.Pairs()) != "a,y" {
+		t.Fatal("wrong merge:", err, pairKeys(o.Pairs()))
+	}
+
+	// SetAll skips violating records and returns error
+	o = newMap(Pair[string, int]{"a", 1})
+	err = o.SetAll(newMap(Pair[string, int]{"b", 1},
+		Pair[string, int]{"c", 2}).Records())
+	if !errors.Is(err, ErrUniqueConstraint) || pairKeys(o.Pairs()) != "a,c" {
+		t.Fatal("wrong SetAll:", err, pairKeys(o.Pairs()))
+	}
+	if err = o.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Decoders return error
+	o = newMap()
+	err = json.Unmarshal([]byte(`{"a":1,"b":1,"c":2}`), o)
+	if !errors.Is(err, ErrUniqueConstraint) || o.Len() != 0 {
+		t.Fatal("wrong JSON decode:", err, o.Len())
+	}
+	src, err := New[string, int]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.Set("a", 1)
+	src.Set("b", 1)
+	data, err := src.GobEncode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = o.GobDecode(data); !errors.Is(err, ErrUniqueConstraint) ||
+		o.Len() != 0 {
+		t.Fatal("wrong gob decode:", err, o.Len())
+	}
+
+	// Get or set methods report rejected record
+	o = newMap(Pair[string, int]{"a", 1})
+	if _, loaded, err := o.GetOrSet("b", 1); loaded ||
+		!errors.Is(err, ErrUniqueConstraint) || o.Exists("b") {
+		t.Fatal("wrong GetOrSet:", loaded, err)
+	}
+	_, _, err = o.GetOrSetFunc("b", func() int { return 1 })
+	if !errors.Is(err, ErrUniqueConstraint) || o.Exists("b") {
+		t.Fatal("wrong GetOrSetFunc:", err)
+	}
+	if rec, loaded := o.LoadOrStoreRecord("b", 1); rec != nil || loaded {
+		t.Fatal("wrong LoadOrStoreRecord:", rec, loaded)
+	}
+}
+
 func CompareByName(r1, r2 *Record[string, *Person]) int {
 	return strings.Compare(
 		strings.ToLower(r1.Data().Name), strings.ToLower(r2.Data().Name),
@@ -320,3 +3174,60 @@ func CompareByAgeAsc(r1, r2 *Record[string, *Person]) int {
 func CompareByAgeDesc(r1, r2 *Record[string, *Person]) int {
 	return r2.Data().Age - r1.Data().Age
 }
+
+func BenchmarkChurn(b *testing.B) {
+	benchmarkChurn(b, false)
+}
+
+func BenchmarkChurnPool(b *testing.B) {
+	benchmarkChurn(b, true)
+}
+
+// benchmarkChurn inserts and deletes records in loop.
+func benchmarkChurn(b *testing.B, pool bool) {
+	m, err := New(
+		Index[int, int]{Key: "key", Func: CompareByKey[int, int]},
+		WithRecordPool[int, int](pool),
+	)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; b.Loop(); i++ {
+		m.Set(i, i)
+		m.Del(i)
+	}
+}
+
+func BenchmarkForEachPair(b *testing.B) {
+	m, err := New[int, int]()
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := range 1000 {
+		m.Set(i, i)
+	}
+	b.ReportAllocs()
+	for b.Loop() {
+		m.ForEachPair(func(pair Pair[int, int]) {})
+	}
+}
+
+func BenchmarkSetIndexed(b *testing.B) {
+	for _, n := range []int{10_000, 50_000} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			keys := rand.New(rand.NewPCG(1, 2)).Perm(n)
+			for b.Loop() {
+				o, _ := New(
+					Index[int, int]{Key: "key", Func: CompareByKey[int, int]},
+					Index[int, int]{Key: "desc", Func: CompareByKeyDesc[int, int]},
+					Index[int, int]{Key: "value",
+						Func: CompareByValueThenKey[int, int]},
+				)
+				for _, key := range keys {
+					o.Set(key, key%100)
+				}
+			}
+		})
+	}
+}
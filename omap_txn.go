@@ -0,0 +1,167 @@
+// Copyright 2025 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Transaction of ordered map definition.
+
+package omap
+
+// Txn is a transaction which collects Set and Del operations and applies them
+// to ordered map all at once on Commit, or discards them on Rollback. Use
+// Begin to create it.
+//
+// Operations are applied under one Lock, so other goroutines never see partial
+// state. If any operation fails, all applied operations are undone and the map
+// is left unchanged. Txn is not safe for concurrent use.
+type Txn[K comparable, D any] struct {
+	m    *Omap[K, D]
+	ops  []txnOp[K, D]
+	done bool
+}
+
+// txnOp is a transaction operation.
+type txnOp[K comparable, D any] struct {
+	key  K
+	data D
+	del  bool
+}
+
+// Begin creates new transaction of ordered map.
+func (m *Omap[K, D]) Begin() *Txn[K, D] {
+	return &Txn[K, D]{m: m}
+}
+
+// Set adds set operation to transaction. On Commit it adds or updates record
+// like Set. It returns ErrTxnDone if transaction is committed or rolled back.
+func (t *Txn[K, D]) Set(key K, data D) error {
+	if t.done {
+		return ErrTxnDone
+	}
+	t.ops = append(t.ops, txnOp[K, D]{key: key, data: data})
+	return nil
+}
+
+// Del adds delete operation to transaction. On Commit it removes record like
+// Del, missing key is skipped. It returns ErrTxnDone if transaction is
+// committed or rolled back.
+func (t *Txn[K, D]) Del(key K) error {
+	if t.done {
+		return ErrTxnDone
+	}
+	t.ops = append(t.ops, txnOp[K, D]{key: key, del: true})
+	return nil
+}
+
+// Rollback discards transaction operations. It returns ErrTxnDone if
+// transaction is committed or rolled back.
+func (t *Txn[K, D]) Rollback() error {
+	if t.done {
+		return ErrTxnDone
+	}
+	t.done, t.ops = true, nil
+	return nil
+}
+
+// Commit applies transaction operations to ordered map in order of their
+// adding under one Lock and sorts indexes once. If any operation fails, for
+// example with ErrUniqueConstraint, the applied operations are undone, so
+// records, their data and order are restored, and the error is returned. It
+// returns ErrTxnDone if transaction is committed or rolled back.
+//
+// Changes are written to journal and sent to subscribers (see Journal and
+// Subscribe) only after all operations are applied, a failed Commit writes and
+// sends nothing.
+func (t *Txn[K, D]) Commit() (err error) {
+	if t.done {
+		return ErrTxnDone
+	}
+	t.done = true
+
+	m := t.m
+	m.Lock()
+	defer m.Unlock()
+
+	// Buffer changes until operations are applied
+	var pending []change[K, D]
+	m.pending = &pending
+
+	// Apply operations and collect functions which undo them
+	var undo []func()
+	for _, op := range t.ops {
+		if err = m.applyOp(op, &undo); err != nil {
+			break
+		}
+	}
+
+	// Undo applied operations in reverse order on error
+	if err != nil {
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
+		}
+	}
+	m.pending = nil
+	m.Idx.sort()
+
+	// Discard buffered changes on error
+	if err != nil {
+		return
+	}
+
+	// Write changes to journal and send events to subscribers
+	for _, c := range pending {
+		m.Idx.changed(c.op, c.key, c.data)
+	}
+
+	return
+}
+
+// applyOp applies transaction operation and adds function which undoes it to
+// undo. Unsafe (does not lock).
+func (m *Omap[K, D]) applyOp(op txnOp[K, D], undo *[]func()) (err error) {
+	mapKey := m.Idx.mapKey(op.key)
+	rec, exists := m.m[mapKey]
+
+	switch {
+
+	// Remove record and restore it before its next record on undo
+	case op.del && exists:
+		key, data := rec.Key(), rec.Data()
+		var nextKey K
+		next := m.Idx.next(rec)
+		if next != nil {
+			nextKey = m.Idx.mapKey(next.Key())
+		}
+		m.Idx.removeRecord(rec)
+		*undo = append(*undo, func() {
+			direction, mark := back, (*Record[K, D])(nil)
+			if next != nil {
+				direction, mark = before, m.m[nextKey]
+			}
+			m.m[mapKey] = m.Idx.insert(key, data, direction, mark)
+		})
+
+	case op.del:
+
+	// Update record and restore its key and data on undo
+	case exists:
+		key, data := rec.Key(), rec.Data()
+		if err = m.put(op.key, op.data, back); err != nil {
+			return
+		}
+		*undo = append(*undo, func() {
+			r := m.m[mapKey]
+			r.value().Key = key
+			r.Update(data)
+			m.Idx.changed(JournalSet, key, data)
+		})
+
+	// Add record and remove it on undo
+	default:
+		if err = m.put(op.key, op.data, back); err != nil {
+			return
+		}
+		*undo = append(*undo, func() { m.Idx.removeRecord(m.m[mapKey]) })
+	}
+
+	return
+}
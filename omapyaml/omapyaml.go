@@ -0,0 +1,50 @@
+// Copyright 2025 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package omapyaml provides YAML marshaling of ordered maps which keeps the
+// records order.
+//
+// The package is separated from omap package so only users who need YAML
+// depend on gopkg.in/yaml.v3 package.
+package omapyaml
+
+import (
+	"github.com/kirill-scherba/omap"
+	"gopkg.in/yaml.v3"
+)
+
+// Map wraps ordered map with string keys to marshal it to YAML mapping in
+// order of default (insertion) index.
+type Map[D any] struct {
+	*omap.Omap[string, D]
+}
+
+// MarshalYAML returns YAML mapping node with ordered map records in order of
+// default (insertion) index. Nil map is marshaled to empty mapping.
+func (m Map[D]) MarshalYAML() (any, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	if m.Omap == nil {
+		return node, nil
+	}
+
+	// Add records key and value nodes
+	for _, pair := range m.Pairs() {
+		key := &yaml.Node{}
+		if err := key.Encode(pair.Key); err != nil {
+			return nil, err
+		}
+		value := &yaml.Node{}
+		if err := value.Encode(pair.Value); err != nil {
+			return nil, err
+		}
+		node.Content = append(node.Content, key, value)
+	}
+
+	return node, nil
+}
+
+// Marshal serializes ordered map to YAML document which keeps records order.
+func Marshal[D any](m *omap.Omap[string, D]) ([]byte, error) {
+	return yaml.Marshal(Map[D]{m})
+}
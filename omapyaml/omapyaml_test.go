@@ -0,0 +1,39 @@
+package omapyaml
+
+import (
+	"testing"
+
+	"github.com/kirill-scherba/omap"
+)
+
+func TestMarshal(t *testing.T) {
+	t.Log("TestMarshal")
+
+	type Server struct {
+		Host string `yaml:"host"`
+		Port int    `yaml:"port"`
+	}
+
+	// Create new ordered map
+	m, err := omap.New[string, Server]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Set("web", Server{"localhost", 8080})
+	m.Set("db", Server{"localhost", 5432})
+	m.Set("cache", Server{"127.0.0.1", 6379})
+
+	// Marshal ordered map
+	data, err := Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log("\n" + string(data))
+
+	expected := "web:\n    host: localhost\n    port: 8080\n" +
+		"db:\n    host: localhost\n    port: 5432\n" +
+		"cache:\n    host: 127.0.0.1\n    port: 6379\n"
+	if string(data) != expected {
+		t.Fatal("wrong yaml:\n" + string(data))
+	}
+}